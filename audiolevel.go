@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"os/exec"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Decoded audio is mono 16-bit PCM at this rate, measured in half-second windows
+const (
+	levelSampleRate  = 8000
+	levelWindowBytes = levelSampleRate // 0.5s of 2-byte samples
+)
+
+var audioLevel = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "radio_stream_audio_level",
+		Help: "Rolling audio level of active streams as a fraction of full scale",
+	},
+	[]string{"station", "type"},
+)
+
+// Decodes a sample of each active station's audio with ffmpeg to publish
+// peak/RMS levels. At most one decoder runs per station regardless of how
+// many listeners it has.
+type levelMeter struct {
+	ffmpeg string
+	logger *log.Logger
+
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+func newLevelMeter(config Config, logger *log.Logger) *levelMeter {
+	return &levelMeter{
+		ffmpeg: config.FFmpegPath,
+		logger: logger,
+		active: make(map[string]bool),
+	}
+}
+
+// Start metering a station unless it already is. The returned tap never
+// blocks or fails: audio that ffmpeg can't keep up with is dropped so the
+// passthrough to the client is never slowed down.
+func (m *levelMeter) attach(station string) (io.WriteCloser, bool) {
+	m.mu.Lock()
+	if m.active[station] {
+		m.mu.Unlock()
+		return nil, false
+	}
+	m.active[station] = true
+	m.mu.Unlock()
+
+	cmd := exec.Command(m.ffmpeg, "-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0", "-f", "s16le", "-ac", "1", "-ar", "8000", "pipe:1")
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		var stdout io.ReadCloser
+		if stdout, err = cmd.StdoutPipe(); err == nil {
+			if err = cmd.Start(); err == nil {
+				tap := &levelTap{chunks: make(chan []byte, 64), done: make(chan struct{})}
+				go tap.feed(stdin)
+				go m.measure(station, stdout, cmd, tap)
+				return tap, true
+			}
+		}
+	}
+
+	m.logger.Printf("Audio level metering unavailable for %s: %v", station, err)
+	m.release(station)
+	return nil, false
+}
+
+func (m *levelMeter) release(station string) {
+	m.mu.Lock()
+	delete(m.active, station)
+	m.mu.Unlock()
+	audioLevel.DeleteLabelValues(station, "peak")
+	audioLevel.DeleteLabelValues(station, "rms")
+}
+
+func (m *levelMeter) measure(station string, pcm io.Reader, cmd *exec.Cmd, tap *levelTap) {
+	defer m.release(station)
+	defer cmd.Wait()
+
+	window := make([]byte, levelWindowBytes)
+	for {
+		if _, err := io.ReadFull(pcm, window); err != nil {
+			return
+		}
+		peak, rms := pcmLevels(window)
+		audioLevel.WithLabelValues(station, "peak").Set(peak)
+		audioLevel.WithLabelValues(station, "rms").Set(rms)
+	}
+}
+
+// Peak and RMS of little-endian 16-bit samples, scaled to 0..1
+func pcmLevels(pcm []byte) (peak, rms float64) {
+	var sumSquares float64
+	samples := len(pcm) / 2
+	for i := 0; i < samples; i++ {
+		v := math.Abs(float64(int16(binary.LittleEndian.Uint16(pcm[2*i:])))) / 32768
+		if v > peak {
+			peak = v
+		}
+		sumSquares += v * v
+	}
+	if samples > 0 {
+		rms = math.Sqrt(sumSquares / float64(samples))
+	}
+	return peak, rms
+}
+
+// Non-blocking copy of the stream into ffmpeg's stdin
+type levelTap struct {
+	chunks chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (t *levelTap) Write(p []byte) (int, error) {
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+	select {
+	case t.chunks <- chunk:
+	case <-t.done:
+	default:
+	}
+	return len(p), nil
+}
+
+func (t *levelTap) Close() error {
+	t.once.Do(func() { close(t.done) })
+	return nil
+}
+
+func (t *levelTap) feed(stdin io.WriteCloser) {
+	defer stdin.Close()
+	for {
+		select {
+		case chunk := <-t.chunks:
+			if _, err := stdin.Write(chunk); err != nil {
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func authEnabled(config Config) bool {
+	return config.APIKey != "" || config.BasicUser != ""
+}
+
+// Require a bearer token or Basic credentials on every route except the
+// exempt paths. A no-op when neither is configured.
+func authMiddleware(config Config) gin.HandlerFunc {
+	exempt := make(map[string]bool)
+	for _, path := range config.AuthExempt {
+		exempt[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if !authEnabled(config) || exempt[c.Request.URL.Path] || c.Request.Method == http.MethodOptions {
+			return
+		}
+		if authorized(config, c.Request) {
+			return
+		}
+		if config.BasicUser != "" {
+			c.Header("WWW-Authenticate", `Basic realm="radio", charset="UTF-8"`)
+		} else {
+			c.Header("WWW-Authenticate", "Bearer")
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	}
+}
+
+// Without credentials anyone could override stations or flush the cache,
+// so /admin then only answers requests from this host. The socket address
+// is used since forwarded headers are the client's to choose.
+func adminGuard(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authEnabled(config) || isLoopbackRequest(c.Request) {
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin routes are only served to localhost unless credentials are configured"})
+	}
+}
+
+func isLoopbackRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func authorized(config Config, r *http.Request) bool {
+	if config.APIKey != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(token, config.APIKey) {
+			return true
+		}
+	}
+	if config.BasicUser != "" {
+		if user, pass, ok := r.BasicAuth(); ok && secureEqual(user, config.BasicUser) && secureEqual(pass, config.BasicPassword) {
+			return true
+		}
+	}
+	return false
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Add whichever credentials are configured to a request to ourselves
+func setAuth(config Config, req *http.Request) {
+	switch {
+	case config.APIKey != "":
+		req.Header.Set("Authorization", "Bearer "+config.APIKey)
+	case config.BasicUser != "":
+		req.SetBasicAuth(config.BasicUser, config.BasicPassword)
+	}
+}
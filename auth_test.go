@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminNeedsCredentialsOrLoopback(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiKey     string
+		remoteAddr string
+		token      string
+		want       int
+	}{
+		{"no credentials, remote with a forwarded loopback", "", "192.0.2.7:5000", "", http.StatusForbidden},
+		{"no credentials, loopback", "", "127.0.0.1:5000", "", http.StatusOK},
+		{"no credentials, loopback v6", "", "[::1]:5000", "", http.StatusOK},
+		{"credentials, remote without token", "secret", "192.0.2.7:5000", "", http.StatusUnauthorized},
+		{"credentials, remote with token", "secret", "192.0.2.7:5000", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig("http://catalog.example/")
+			config.APIKey = tt.apiKey
+			r := gin.New()
+			r.Use(authMiddleware(config))
+			admin := r.Group("/admin", adminGuard(config))
+			admin.POST("/cache/flush", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/admin/cache/flush", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "127.0.0.1")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
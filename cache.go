@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Upper bound on a shared refresh, which no single caller can cancel
+const cacheRefreshTimeout = 30 * time.Second
+
+// How long a stale catalog is served after a failed refresh before the
+// upstream is tried again, so an outage doesn't stall every lookup
+const staleRetryInterval = 5 * time.Second
+
+var stationCacheRequests = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radio_station_cache_requests_total",
+		Help: "Station list lookups by cache result: hit, miss, or stale (served from cache while the upstream is failing)",
+	},
+	[]string{"result"},
+)
+
+// Decoded catalog shared by all handlers for ttl
+type stationCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	stations  []RadioStation
+	fetchedAt time.Time
+	// Lookups before this are served from cache; after a failed refresh
+	// it is only staleRetryInterval away and stale is set
+	freshUntil time.Time
+	stale      bool
+	// Bumped by flushes and reloads; older refreshes don't store results
+	gen      uint64
+	inflight *cacheRefresh
+}
+
+type cacheRefresh struct {
+	gen      uint64
+	done     chan struct{}
+	stations []RadioStation
+	err      error
+	// Why the load failed, even when a stale copy was served instead
+	loadErr error
+}
+
+// Age of the cached catalog, or -1 if nothing is cached
+func (c *stationCache) age() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stations == nil {
+		return -1
+	}
+	return time.Since(c.fetchedAt).Seconds()
+}
+
+// Force the next fetch to refresh, still falling back to the old copy
+func (c *stationCache) expire() {
+	c.mu.Lock()
+	c.freshUntil = time.Time{}
+	c.gen++
+	c.mu.Unlock()
+}
+
+// The station catalog, from cache while it's fresh. Concurrent misses
+// share one refresh; if it fails the last good copy is served instead.
+func (a *stationsAPI) fetch(ctx context.Context) ([]RadioStation, error) {
+	c := a.cache
+	if c.ttl <= 0 {
+		stations, _, err := a.load(ctx)
+		return stations, err
+	}
+
+	c.mu.Lock()
+	if c.stations != nil && time.Now().Before(c.freshUntil) {
+		stations, result := c.stations, "hit"
+		if c.stale {
+			result = "stale"
+		}
+		c.mu.Unlock()
+		stationCacheRequests.WithLabelValues(result).Inc()
+		return stations, nil
+	}
+	stationCacheRequests.WithLabelValues("miss").Inc()
+	refresh := c.inflight
+	if refresh == nil {
+		refresh = a.startRefreshLocked()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.stations, refresh.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Start a refresh that lookups join until it finishes; c.mu is held
+func (a *stationsAPI) startRefreshLocked() *cacheRefresh {
+	r := &cacheRefresh{gen: a.cache.gen, done: make(chan struct{})}
+	a.cache.inflight = r
+	go a.refresh(r)
+	return r
+}
+
+func (a *stationsAPI) refresh(r *cacheRefresh) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheRefreshTimeout)
+	defer cancel()
+	stations, failed, err := a.load(ctx)
+
+	c := a.cache
+	c.mu.Lock()
+	defer close(r.done)
+	defer c.mu.Unlock()
+	if c.inflight == r {
+		c.inflight = nil
+	}
+	r.loadErr = err
+
+	// A flush started since; its result must not be replaced by this one
+	if r.gen != c.gen {
+		r.stations, r.err = stations, err
+		if err != nil && c.stations != nil {
+			r.stations, r.err = c.stations, nil
+		}
+		return
+	}
+
+	served, stale, storeErr := c.storeLocked(stations, failed, err)
+	if stale {
+		a.logger.Printf("Serving cached stations after refresh failure: %v", err)
+	}
+	r.stations, r.err = served, storeErr
+}
+
+// Refresh the catalog even while the cache is fresh, joining a refresh
+// that is already running, and report whether the upstream load failed.
+// Not counted as a cache lookup.
+func (a *stationsAPI) warm(ctx context.Context) error {
+	c := a.cache
+	if c.ttl <= 0 {
+		_, _, err := a.load(ctx)
+		return err
+	}
+
+	c.mu.Lock()
+	refresh := c.inflight
+	if refresh == nil {
+		refresh = a.startRefreshLocked()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.loadErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reload the catalog now instead of waiting out the ttl. Refreshes already
+// running are superseded, and the cached copy is only replaced once the
+// reload succeeds, so a failing upstream doesn't leave the cache empty.
+func (a *stationsAPI) flush(ctx context.Context) ([]RadioStation, error) {
+	c := a.cache
+	if c.ttl <= 0 {
+		stations, _, err := a.load(ctx)
+		return stations, err
+	}
+
+	c.mu.Lock()
+	c.gen++
+	c.freshUntil = time.Time{}
+	refresh := a.startRefreshLocked()
+	c.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		if refresh.loadErr != nil {
+			return nil, refresh.loadErr
+		}
+		return refresh.stations, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Inspect the station cache without touching the upstream
+func cacheStatusHandler(api *stationsAPI) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cache := api.cache
+		cache.mu.Lock()
+		size, stale := len(cache.stations), cache.stale
+		cache.mu.Unlock()
+		c.JSON(http.StatusOK, gin.H{
+			"ttl_seconds": cache.ttl.Seconds(),
+			"age_seconds": cache.age(),
+			"stations":    size,
+			"stale":       stale,
+		})
+	}
+}
+
+// Drop the cached catalog and refetch it, so upstream changes show up
+// without waiting for the ttl or restarting
+func cacheFlushHandler(api *stationsAPI, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stations, err := api.flush(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		logger.Printf("Station cache flushed; %d stations loaded", len(stations))
+		c.JSON(http.StatusOK, gin.H{"stations": len(stations)})
+	}
+}
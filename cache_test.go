@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A catalog whose contents, or failure, can be changed between fetches
+type fakeCatalog struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	stations []RadioStation
+	failing  bool
+	requests int
+	// When set, the next request answers with the current stations only
+	// once this is closed
+	hold chan struct{}
+}
+
+func newFakeCatalog(t *testing.T, stations ...RadioStation) *fakeCatalog {
+	t.Helper()
+	f := &fakeCatalog{stations: stations}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests++
+		if hold := f.hold; hold != nil {
+			f.hold = nil
+			stations := f.stations
+			f.mu.Unlock()
+			<-hold
+			json.NewEncoder(w).Encode(stations)
+			return
+		}
+		defer f.mu.Unlock()
+		if f.failing {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(f.stations)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeCatalog) set(failing bool, stations ...RadioStation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing, f.stations = failing, stations
+}
+
+func (f *fakeCatalog) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func stationNames(stations []RadioStation) []string {
+	names := make([]string, 0, len(stations))
+	for _, station := range stations {
+		names = append(names, station.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expire the cache and fetch through it, as the next request would
+func refetch(t *testing.T, api *stationsAPI) []string {
+	t.Helper()
+	api.cache.expire()
+	stations, err := api.fetch(context.Background())
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	return stationNames(stations)
+}
+
+var (
+	jazz = RadioStation{ID: 1, Name: "Jazz", URL: "http://jazz.example/live"}
+	rock = RadioStation{ID: 2, Name: "Rock", URL: "http://rock.example/live"}
+	folk = RadioStation{ID: 3, Name: "Folk", URL: "http://folk.example/live"}
+)
+
+func TestCacheEvictsStationsOnSuccessfulRefresh(t *testing.T) {
+	catalog := newFakeCatalog(t, jazz, rock)
+	config := testConfig(catalog.URL)
+	config.CacheTTL = time.Hour
+	api := newStationsAPI(config, testLogger)
+
+	if got := refetch(t, api); len(got) != 2 {
+		t.Fatalf("initial catalog = %v", got)
+	}
+	catalog.set(false, jazz)
+	if got := refetch(t, api); len(got) != 1 || got[0] != "Jazz" {
+		t.Errorf("after the upstream dropped Rock, catalog = %v, want [Jazz]", got)
+	}
+}
+
+func TestCacheKeepsStaleCatalogOnFailedRefresh(t *testing.T) {
+	catalog := newFakeCatalog(t, jazz, rock)
+	config := testConfig(catalog.URL)
+	config.CacheTTL = time.Hour
+	api := newStationsAPI(config, testLogger)
+
+	refetch(t, api)
+	catalog.set(true)
+	if got := refetch(t, api); len(got) != 2 {
+		t.Errorf("after a failed refresh, catalog = %v, want both stations", got)
+	}
+}
+
+func TestCacheKeepsFailedSourceStations(t *testing.T) {
+	first := newFakeCatalog(t, jazz, rock)
+	second := newFakeCatalog(t, folk)
+	config := testConfig(first.URL + "," + second.URL)
+	config.CacheTTL = time.Hour
+	api := newStationsAPI(config, testLogger)
+
+	refetch(t, api)
+	// The healthy source shrinks while the other one is down
+	first.set(false, jazz)
+	second.set(true)
+	got := refetch(t, api)
+	if want := []string{"Folk", "Jazz"}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("catalog = %v, want %v", got, want)
+	}
+}
+
+func TestCacheBacksOffAfterFailedRefresh(t *testing.T) {
+	catalog := newFakeCatalog(t, jazz, rock)
+	config := testConfig(catalog.URL)
+	config.CacheTTL = time.Hour
+	api := newStationsAPI(config, testLogger)
+
+	refetch(t, api)
+	catalog.set(true)
+	refetch(t, api)
+	requests := catalog.requestCount()
+
+	// Within the retry interval the stale copy is served without waiting
+	// on the failing upstream again
+	before := collectorValues(stationCacheRequests)
+	stations, err := api.fetch(context.Background())
+	if err != nil || len(stations) != 2 {
+		t.Fatalf("fetch during backoff = %v, %v", stationNames(stations), err)
+	}
+	if got := catalog.requestCount(); got != requests {
+		t.Errorf("upstream was asked %d more times during the backoff", got-requests)
+	}
+	after := collectorValues(stationCacheRequests)
+	if after["stale"]-before["stale"] != 1 || after["miss"] != before["miss"] || after["hit"] != before["hit"] {
+		t.Errorf("lookup counted as %v -> %v, want one stale", before, after)
+	}
+}
+
+func TestCacheFlushSupersedesRunningRefresh(t *testing.T) {
+	catalog := newFakeCatalog(t, jazz)
+	config := testConfig(catalog.URL)
+	config.CacheTTL = time.Hour
+	api := newStationsAPI(config, testLogger)
+	refetch(t, api)
+
+	// A refresh starts and is held with the old catalog...
+	hold := make(chan struct{})
+	catalog.mu.Lock()
+	catalog.hold = hold
+	catalog.mu.Unlock()
+	api.cache.expire()
+	stale := make(chan struct{})
+	go func() {
+		api.fetch(context.Background())
+		close(stale)
+	}()
+	for catalog.requestCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...while a flush loads the new one
+	catalog.set(false, jazz, rock)
+	stations, err := api.flush(context.Background())
+	if err != nil || len(stations) != 2 {
+		t.Fatalf("flush = %v, %v", stationNames(stations), err)
+	}
+	close(hold)
+	<-stale
+
+	stations, err = api.fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stationNames(stations); len(got) != 2 {
+		t.Errorf("after the older refresh finished, catalog = %v, want the flushed one", got)
+	}
+}
+
+func TestCatalogProbeSharesRefreshes(t *testing.T) {
+	catalog := newFakeCatalog(t, jazz)
+	config := testConfig(catalog.URL)
+	config.CacheTTL = time.Hour
+	api := newStationsAPI(config, testLogger)
+
+	// The probe's refresh is held while a lookup arrives
+	hold := make(chan struct{})
+	catalog.mu.Lock()
+	catalog.hold = hold
+	catalog.mu.Unlock()
+	warmed := make(chan error)
+	go func() { warmed <- api.warm(context.Background()) }()
+	for catalog.requestCount() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	misses := collectorValues(stationCacheRequests)["miss"]
+	fetched := make(chan error)
+	go func() {
+		_, err := api.fetch(context.Background())
+		fetched <- err
+	}()
+	// A miss is counted as the lookup joins the refresh
+	for collectorValues(stationCacheRequests)["miss"] == misses {
+		time.Sleep(time.Millisecond)
+	}
+	close(hold)
+	if err := <-warmed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-fetched; err != nil {
+		t.Fatal(err)
+	}
+	if got := catalog.requestCount(); got != 1 {
+		t.Errorf("upstream was asked %d times, want the lookup to join the probe's refresh", got)
+	}
+
+	// A probe on a fresh cache still reloads, and lookups after it are hits
+	catalog.set(false, jazz, rock)
+	if err := api.warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	before := collectorValues(stationCacheRequests)
+	stations, err := api.fetch(context.Background())
+	if err != nil || len(stations) != 2 {
+		t.Fatalf("fetch after the probe = %v, %v, want both stations", stationNames(stations), err)
+	}
+	if after := collectorValues(stationCacheRequests); after["hit"]-before["hit"] != 1 {
+		t.Errorf("lookup after the probe counted as %v -> %v, want a hit", before, after)
+	}
+}
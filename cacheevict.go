@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// Fold a finished refresh into the cache and return what to serve; the
+// caller holds c.mu.
+//
+// A failed refresh says nothing about which stations still exist, so the
+// whole stale catalog is kept and served (stale is true); it is only an
+// error when nothing is cached yet. A successful refresh replaces the
+// catalog, evicting stations the upstream dropped, except that a source
+// which failed this time keeps its previous stations.
+func (c *stationCache) storeLocked(stations []RadioStation, failed map[string]bool, err error) (served []RadioStation, stale bool, _ error) {
+	now := time.Now()
+	if err != nil {
+		if c.stations == nil {
+			return nil, false, err
+		}
+		c.stale = true
+		c.freshUntil = now.Add(min(c.ttl, staleRetryInterval))
+		return c.stations, true, nil
+	}
+
+	if len(failed) > 0 && c.stations != nil {
+		stations = keepFailedSources(stations, c.stations, failed)
+	}
+	c.stations = stations
+	c.fetchedAt = now
+	c.freshUntil = now.Add(c.ttl)
+	c.stale = false
+	return stations, false, nil
+}
+
+// Add back the previous stations of sources that failed to load, unless
+// a source that did load now supplies the same name
+func keepFailedSources(fresh, previous []RadioStation, failed map[string]bool) []RadioStation {
+	seen := make(map[string]bool, len(fresh))
+	for _, station := range fresh {
+		seen[station.MatchKey] = true
+	}
+	for _, station := range previous {
+		if failed[station.Source] && !seen[station.MatchKey] {
+			fresh = append(fresh, station)
+		}
+	}
+	return fresh
+}
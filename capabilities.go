@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Describe what this deployment supports, derived from the effective
+// config, so clients can adapt their UI
+func capabilitiesHandler(config Config) gin.HandlerFunc {
+	formats := make([]string, 0, len(forcedContentTypes))
+	for ct := range forcedContentTypes {
+		formats = append(formats, ct)
+	}
+	sort.Strings(formats)
+
+	features := gin.H{
+		"availability":    config.ProbeStations,
+		"audio_levels":    config.AudioLevels,
+		"batch_resolve":   true,
+		"download":        true,
+		"embed_logos":     true,
+		"playlists":       config.PlaylistMaxDepth > 0,
+		"qr_codes":        true,
+		"expvar":          config.EnableExpvar,
+		"virtual_station": config.VirtualStation != "",
+		"transcode":       false,
+		"hls":             config.HLS,
+		"websocket":       true,
+		"relay":           config.Relay,
+		"now_playing":     true,
+	}
+
+	caps := gin.H{
+		"features":       features,
+		"auth_required":  authEnabled(config),
+		"forced_formats": formats,
+		"limits": gin.H{
+			"max_active_streams":         config.MaxActiveStreams,
+			"max_api_connections":        config.MaxAPIConns,
+			"max_connections_per_origin": config.MaxConnsPerOrigin,
+			"max_resolve_batch":          maxResolveBatch,
+			"max_body_bytes":             config.MaxBodyBytes,
+			"stream_start_timeout":       config.StreamStartTimeout.String(),
+			"stream_idle_timeout":        config.StreamIdleTimeout.String(),
+		},
+	}
+	if config.VirtualStation != "" {
+		caps["virtual_station"] = config.VirtualStation
+	}
+
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, caps)
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	errParseCatalog    = errors.New("failed to parse stations")
+	errCatalogUpstream = errors.New("stations API returned an error")
+)
+
+// One input to the merged station catalog: either an HTTP endpoint
+// (with optional request headers for auth) or a local JSON file
+type stationSource struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	File    string            `json:"file"`
+	Headers map[string]string `json:"headers"`
+}
+
+func (s stationSource) label() string {
+	switch {
+	case s.Name != "":
+		return s.Name
+	case s.File != "":
+		return s.File
+	default:
+		return s.URL
+	}
+}
+
+// Load the ordered source list from a JSON file
+func loadStationSources(path string) ([]stationSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sources []stationSource
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, err
+	}
+	for i, src := range sources {
+		if (src.URL == "") == (src.File == "") {
+			return nil, fmt.Errorf("source %d must set exactly one of url or file", i)
+		}
+	}
+	return sources, nil
+}
+
+// Client for the upstream stations API. When a connection limit is
+// configured, requests beyond it queue for up to queueTimeout and then
+// fail with a limitError.
+type stationsAPI struct {
+	sourcesMu    sync.RWMutex
+	sources      []stationSource
+	logger       *log.Logger
+	slots        chan struct{}
+	queueTimeout time.Duration
+	names        nameNormalizer
+	schemas      []fieldMap
+	slowFetch    time.Duration
+	changes      *catalogWatcher
+	suggest      bool
+	cache        *stationCache
+	client       *http.Client
+	userAgent    string
+	preferLast   bool
+	status       *catalogStatus
+	collisions   *slugCollisions
+}
+
+// Slug collisions already logged, so each is reported once rather than on
+// every catalog load
+type slugCollisions struct {
+	mu   sync.Mutex
+	seen map[[3]string]bool
+}
+
+func (s *slugCollisions) firstReport(slug, owner, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [3]string{slug, owner, name}
+	if s.seen[key] {
+		return false
+	}
+	s.seen[key] = true
+	return true
+}
+
+func newStationsAPI(config Config, logger *log.Logger) *stationsAPI {
+	api := &stationsAPI{
+		sources:      config.Sources,
+		logger:       logger,
+		queueTimeout: config.APIQueueTimeout,
+		names:        nameNormalizer{trim: config.TrimNames, collapse: config.CollapseNames},
+		schemas:      config.Schemas,
+		slowFetch:    config.SlowCatalogThreshold,
+		changes:      newCatalogWatcher(config, logger),
+		suggest:      config.SuggestStations,
+		cache:        &stationCache{ttl: config.CacheTTL},
+		client:       config.APIClient,
+		userAgent:    primaryUserAgent(config),
+		preferLast:   config.CatalogMerge == "last",
+		status:       &catalogStatus{},
+		collisions:   &slugCollisions{seen: make(map[[3]string]bool)},
+	}
+	if len(api.sources) == 0 {
+		for _, endpoint := range splitList(config.APIEndpoint) {
+			api.sources = append(api.sources, stationSource{URL: endpoint})
+		}
+	}
+	if config.MaxAPIConns > 0 {
+		api.slots = make(chan struct{}, config.MaxAPIConns)
+	}
+	return api
+}
+
+func (a *stationsAPI) acquire(ctx context.Context) error {
+	if a.slots == nil {
+		return nil
+	}
+
+	select {
+	case a.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if a.queueTimeout <= 0 {
+		return a.busy()
+	}
+
+	timer := time.NewTimer(a.queueTimeout)
+	defer timer.Stop()
+	select {
+	case a.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return a.busy()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *stationsAPI) busy() error {
+	return &limitError{
+		Limit:      "api_connections",
+		Current:    len(a.slots),
+		Max:        cap(a.slots),
+		RetryAfter: time.Second,
+	}
+}
+
+func (a *stationsAPI) release() {
+	if a.slots != nil {
+		<-a.slots
+	}
+}
+
+// Swap in a reloaded source list; fetches already running keep the old one
+func (a *stationsAPI) setSources(sources []stationSource) {
+	a.sourcesMu.Lock()
+	a.sources = sources
+	a.sourcesMu.Unlock()
+	a.cache.expire()
+}
+
+// Fetch every source concurrently and merge them into one catalog. When a
+// name or ID collides, earlier sources win (later ones with preferLast); a
+// failing source is skipped unless all fail, and its label is returned in
+// failed.
+func (a *stationsAPI) load(ctx context.Context) (merged []RadioStation, failed map[string]bool, err error) {
+	a.sourcesMu.RLock()
+	sources := a.sources
+	a.sourcesMu.RUnlock()
+
+	type result struct {
+		stations []RadioStation
+		err      error
+	}
+	results := make([]result, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stations, err := a.fetchSource(ctx, src)
+			results[i] = result{stations, err}
+		}()
+	}
+	wg.Wait()
+
+	order := make([]int, len(sources))
+	for i := range order {
+		order[i] = i
+		if a.preferLast {
+			order[i] = len(sources) - 1 - i
+		}
+	}
+
+	failed = make(map[string]bool)
+	seenNames := make(map[string]bool)
+	seenIDs := make(map[int]bool)
+	slugOwners := make(map[string]RadioStation)
+
+	var lastErr error
+	loaded := 0
+	for _, i := range order {
+		src, stations, err := sources[i], results[i].stations, results[i].err
+		if err != nil {
+			if len(sources) > 1 {
+				a.logger.Printf("Error loading stations from %s: %v", src.label(), err)
+			}
+			lastErr = err
+			failed[src.label()] = true
+			continue
+		}
+		loaded++
+
+		// Names repeated within one source are all kept; a name already
+		// supplied by an earlier source is not
+		names := make(map[string]bool)
+		for _, station := range stations {
+			station.MatchKey = a.names.key(station.Name)
+			station.Source = src.label()
+			if seenNames[station.MatchKey] || (station.ID != 0 && seenIDs[station.ID]) {
+				continue
+			}
+			names[station.MatchKey] = true
+			if station.ID != 0 {
+				seenIDs[station.ID] = true
+			}
+			station.Slug = slugify(station.Name)
+			if owner, taken := slugOwners[station.Slug]; taken {
+				// Same-name stations are linked as duplicates instead
+				if owner.MatchKey != station.MatchKey && a.collisions.firstReport(station.Slug, owner.Name, station.Name) {
+					a.logger.Printf("Warning: station %q has the same slug %q as %q; the slug stays with %q", station.Name, station.Slug, owner.Name, owner.Name)
+				}
+				station.Slug = ""
+			} else if station.Slug != "" {
+				slugOwners[station.Slug] = station
+			}
+			merged = append(merged, station)
+		}
+		for name := range names {
+			seenNames[name] = true
+		}
+	}
+	markDuplicates(merged)
+
+	if loaded == 0 {
+		a.status.record(0, lastErr)
+		return nil, failed, lastErr
+	}
+	a.status.record(len(merged), nil)
+	// A partial catalog would report the failed sources' stations as removed
+	if loaded == len(sources) {
+		a.changes.observe(merged)
+	}
+	return merged, failed, nil
+}
+
+func (a *stationsAPI) fetchSource(ctx context.Context, src stationSource) ([]RadioStation, error) {
+	if src.File != "" {
+		data, err := os.ReadFile(src.File)
+		if err != nil {
+			return nil, err
+		}
+		return a.decode(src, data)
+	}
+
+	if err := a.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer a.release()
+
+	apiInFlight.Inc()
+	defer apiInFlight.Dec()
+
+	start := time.Now()
+	stations, err := a.fetchRemote(ctx, src)
+	catalogFetchLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		catalogFetchFailures.Inc()
+	}
+	return stations, err
+}
+
+// GET and decode one upstream catalog
+func (a *stationsAPI) fetchRemote(ctx context.Context, src stationSource) ([]RadioStation, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", src.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Per-source headers may still override the agent
+	req.Header.Set("User-Agent", a.userAgent)
+	for key, val := range src.Headers {
+		req.Header.Set(key, val)
+	}
+	start := time.Now()
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%w: %s", errCatalogUpstream, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	warnSlow(a.logger, a.slowFetch, "station list fetch", src.label(), time.Since(start))
+	return a.decode(src, data)
+}
+
+// Decode a catalog payload with each configured schema in turn
+func (a *stationsAPI) decode(src stationSource, data []byte) ([]RadioStation, error) {
+	var errs []string
+	for i, schema := range a.schemas {
+		stations, err := schema.decode(data)
+		if err == nil {
+			if i > 0 {
+				a.logger.Printf("Decoded stations from %s with fallback schema %s", src.label(), schema)
+			}
+			return stations, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", schema, err))
+	}
+	return nil, fmt.Errorf("%w: %s", errParseCatalog, strings.Join(errs, "; "))
+}
+
+// Time a station list fetch at a steady rate so upstream slowdowns show up
+// in apiLatency even when there is no user traffic. Goes through the cache,
+// so it also keeps it warm and never overlaps a user-triggered refresh.
+func (a *stationsAPI) runProbe(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		timer := prometheus.NewTimer(apiLatency.WithLabelValues("probe"))
+		if err := a.warm(ctx); err != nil {
+			a.logger.Printf("Catalog probe failed: %v", err)
+		}
+		timer.ObserveDuration()
+	}
+}
+
+// Flag stations whose name is shared with another
+func markDuplicates(stations []RadioStation) {
+	counts := make(map[string]int, len(stations))
+	for _, station := range stations {
+		counts[station.MatchKey]++
+	}
+	for i := range stations {
+		stations[i].Duplicate = counts[stations[i].MatchKey] > 1
+	}
+}
+
+// Find a station by name, falling back to its slug. Among stations that
+// share a name, an exact case-sensitive match wins, then catalog order.
+func (a *stationsAPI) findStation(stations []RadioStation, name string) (RadioStation, bool) {
+	key := a.names.key(name)
+	match, found := RadioStation{}, false
+	for _, station := range stations {
+		if station.MatchKey != key {
+			continue
+		}
+		if station.Name == name {
+			return station, true
+		}
+		if !found {
+			match, found = station, true
+		}
+	}
+	if found {
+		return match, true
+	}
+	slug := strings.ToLower(name)
+	for _, station := range stations {
+		if station.Slug != "" && station.Slug == slug {
+			return station, true
+		}
+	}
+	return RadioStation{}, false
+}
+
+// URL-safe station name: lowercase letters and digits, with runs of
+// spaces, hyphens and underscores becoming one hyphen and other
+// punctuation dropped. Non-ASCII letters are kept.
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+func findStationByID(stations []RadioStation, id int) (RadioStation, bool) {
+	for _, station := range stations {
+		if station.ID == id {
+			return station, true
+		}
+	}
+	return RadioStation{}, false
+}
+
+// Return the full record for one station by numeric ID
+func getStationByIDHandler(api *stationsAPI, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Station ID must be an integer"})
+			return
+		}
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		station, found := findStationByID(stations, id)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+			return
+		}
+		c.JSON(http.StatusOK, station)
+	}
+}
+
+// Upstream catalogs often carry stray whitespace in names; optionally
+// ignore it so "Jazz FM " still resolves from /stream/jazz%20fm
+type nameNormalizer struct {
+	trim     bool
+	collapse bool
+}
+
+func (n nameNormalizer) key(name string) string {
+	if n.collapse {
+		name = strings.Join(strings.Fields(name), " ")
+	} else if n.trim {
+		name = strings.TrimSpace(name)
+	}
+	return strings.ToLower(name)
+}
+
+// Write the JSON error matching a failed catalog fetch
+func respondCatalogError(c *gin.Context, logger *log.Logger, err error) {
+	logger.Printf("Error loading stations: %v", err)
+
+	var limitErr *limitError
+	switch {
+	case errors.As(err, &limitErr):
+		respondLimit(c, limitErr)
+	case errors.Is(err, errCatalogUpstream):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Stations API returned an error"})
+	case errors.Is(err, errParseCatalog):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse stations"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stations"})
+	}
+}
+
+// Admin view of the merged catalog, including which source each station came from
+func adminStationsHandler(api *stationsAPI, logger *log.Logger, cooldowns *cooldownTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+
+		list := make([]gin.H, 0, len(stations))
+		for _, station := range stations {
+			entry := gin.H{
+				"id":     station.ID,
+				"name":   station.Name,
+				"url":    station.URL,
+				"source": station.Source,
+			}
+			if until := cooldowns.until(station); !until.IsZero() {
+				entry["cooldown_until"] = until
+			}
+			list = append(list, entry)
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestNormalizedNamesResolve(t *testing.T) {
+	catalog := catalogServer(t,
+		RadioStation{ID: 1, Name: "  Jazz FM ", URL: "http://jazz.example/live"},
+		RadioStation{ID: 2, Name: "Rock \t  Radio", URL: "http://rock.example/live"},
+	)
+
+	tests := []struct {
+		name     string
+		trim     bool
+		collapse bool
+		lookup   string
+		want     string
+	}{
+		{"trim ignores outer whitespace", true, false, "jazz fm", "  Jazz FM "},
+		{"trim keeps inner runs", true, false, "rock radio", ""},
+		{"collapse folds inner runs", false, true, "Rock Radio", "Rock \t  Radio"},
+		{"collapse also trims", false, true, "JAZZ FM", "  Jazz FM "},
+		{"off needs the raw name", false, false, "jazz fm", ""},
+		{"off matches the raw name", false, false, "  jazz fm ", "  Jazz FM "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(catalog.URL)
+			config.TrimNames, config.CollapseNames = tt.trim, tt.collapse
+			api := newStationsAPI(config, testLogger)
+
+			stations, err := api.fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			station, found := api.findStation(stations, tt.lookup)
+			if tt.want == "" {
+				if found {
+					t.Fatalf("%q resolved to %q, want no match", tt.lookup, station.Name)
+				}
+				return
+			}
+			if !found {
+				t.Fatalf("%q did not resolve", tt.lookup)
+			}
+			// Responses keep the upstream's display name
+			if station.Name != tt.want {
+				t.Errorf("%q resolved to %q, want %q", tt.lookup, station.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogMergeOrder(t *testing.T) {
+	first := catalogServer(t,
+		RadioStation{ID: 1, Name: "Jazz", URL: "http://first.example/jazz"},
+		RadioStation{ID: 2, Name: "Rock", URL: "http://first.example/rock"},
+	)
+	second := catalogServer(t,
+		RadioStation{ID: 10, Name: "jazz", URL: "http://second.example/jazz"},
+		RadioStation{ID: 2, Name: "Folk", URL: "http://second.example/folk"},
+		RadioStation{ID: 4, Name: "Blues", URL: "http://second.example/blues"},
+	)
+
+	tests := []struct {
+		merge     string
+		wantNames []string
+		wantJazz  string
+	}{
+		// Name and ID collisions both go to the preferred source
+		{"first", []string{"Blues", "Jazz", "Rock"}, "http://first.example/jazz"},
+		{"last", []string{"Blues", "Folk", "jazz"}, "http://second.example/jazz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.merge, func(t *testing.T) {
+			config := testConfig(first.URL + "," + second.URL)
+			config.CatalogMerge = tt.merge
+			api := newStationsAPI(config, testLogger)
+
+			stations, err := api.fetch(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stationNames(stations); strings.Join(got, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("catalog = %v, want %v", got, tt.wantNames)
+			}
+			station, found := api.findStation(stations, "JAZZ")
+			if !found || station.URL != tt.wantJazz {
+				t.Errorf("Jazz resolved to %q (found %t), want %q", station.URL, found, tt.wantJazz)
+			}
+		})
+	}
+}
+
+func TestSlugCollisionsWarnOnce(t *testing.T) {
+	catalog := catalogServer(t,
+		RadioStation{ID: 1, Name: "Rock FM", URL: "http://a.example/rock"},
+		RadioStation{ID: 2, Name: "Rock-FM", URL: "http://b.example/rock"},
+		RadioStation{ID: 3, Name: "Jazz", URL: "http://a.example/jazz"},
+		RadioStation{ID: 4, Name: "Jazz", URL: "http://b.example/jazz"},
+	)
+	var logs bytes.Buffer
+	api := newStationsAPI(testConfig(catalog.URL), log.New(&logs, "", 0))
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := api.load(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(logs.String(), "same slug"); n != 1 {
+		t.Errorf("%d slug warnings over two loads, want 1 for Rock-FM only:\n%s", n, logs.String())
+	}
+	if strings.Contains(logs.String(), `"jazz"`) {
+		t.Errorf("duplicate stations warned about:\n%s", logs.String())
+	}
+}
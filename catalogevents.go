@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	stationsAdded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "radio_stations_added_total",
+		Help: "Stations that appeared in the catalog since startup",
+	})
+	stationsRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "radio_stations_removed_total",
+		Help: "Stations that disappeared from the catalog since startup",
+	})
+)
+
+type catalogEvent struct {
+	Event string    `json:"event"`
+	ID    int       `json:"id"`
+	Name  string    `json:"name"`
+	Time  time.Time `json:"time"`
+}
+
+// Diffs each complete catalog against the previous one, logging added and
+// removed stations and optionally posting them to a webhook
+type catalogWatcher struct {
+	logger  *log.Logger
+	webhook string
+	client  *http.Client
+
+	mu     sync.Mutex
+	known  map[string]RadioStation
+	primed bool
+}
+
+func newCatalogWatcher(config Config, logger *log.Logger) *catalogWatcher {
+	return &catalogWatcher{
+		logger:  logger,
+		webhook: config.CatalogWebhook,
+		client:  &http.Client{Transport: config.StreamClient.Transport, Timeout: 10 * time.Second},
+		known:   make(map[string]RadioStation),
+	}
+}
+
+func (w *catalogWatcher) observe(stations []RadioStation) {
+	current := make(map[string]RadioStation, len(stations))
+	for _, station := range stations {
+		current[station.stateKey()] = station
+	}
+
+	w.mu.Lock()
+	previous, primed := w.known, w.primed
+	w.known, w.primed = current, true
+	w.mu.Unlock()
+
+	// The first catalog is the baseline, not a wave of additions
+	if !primed {
+		return
+	}
+
+	now := time.Now()
+	var events []catalogEvent
+	for key, station := range current {
+		if _, ok := previous[key]; !ok {
+			events = append(events, catalogEvent{Event: "station_added", ID: station.ID, Name: station.Name, Time: now})
+			stationsAdded.Inc()
+		}
+	}
+	for key, station := range previous {
+		if _, ok := current[key]; !ok {
+			events = append(events, catalogEvent{Event: "station_removed", ID: station.ID, Name: station.Name, Time: now})
+			stationsRemoved.Inc()
+			stationUptimes.forget(station)
+		}
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	for _, e := range events {
+		w.logger.Printf("Catalog event=%s id=%d name=%q", e.Event, e.ID, e.Name)
+	}
+	if w.webhook != "" {
+		go w.post(events)
+	}
+}
+
+func (w *catalogWatcher) post(events []catalogEvent) {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return
+	}
+	resp, err := w.client.Post(w.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		w.logger.Printf("Catalog webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		w.logger.Printf("Catalog webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var streamCapRejections = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "radio_stream_capacity_rejections_total",
+		Help: "Stream requests refused because -max-active-streams was reached",
+	},
+)
+
+// Server-wide count of simultaneous streams, capped by -max-active-streams.
+// Streams are counted even while unlimited, so a cap set on reload sees
+// the ones already running.
+type streamCapacity struct {
+	live *liveConfig
+
+	mu     sync.Mutex
+	active int
+}
+
+func newStreamCapacity(live *liveConfig) *streamCapacity {
+	return &streamCapacity{live: live}
+}
+
+// Take a slot, or explain why none is free. The caller must call release
+// once the stream is over.
+func (s *streamCapacity) acquire() (release func(), e *limitError) {
+	max := s.live.get().MaxActiveStreams
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if max > 0 && s.active >= max {
+		streamCapRejections.Inc()
+		return nil, &limitError{
+			Limit:      "active_streams",
+			Current:    s.active,
+			Max:        max,
+			RetryAfter: 5 * time.Second,
+		}
+	}
+	s.active++
+	return func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}, nil
+}
+
+// Caps simultaneous streams server-wide. A slot is taken before the
+// upstream is contacted and given back when the handler returns, so
+// failed connections release it too.
+func streamCapacityLimit(capacity *streamCapacity) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, e := capacity.acquire()
+		if e != nil {
+			respondLimit(c, e)
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// Concurrent streams and stream requests per minute for each client IP.
+// The IP honors X-Forwarded-For only from -trusted-proxies.
+type streamClients struct {
+	live *liveConfig
+
+	mu      sync.Mutex
+	active  map[string]int
+	windows map[string]*scrapeWindow
+}
+
+func newStreamClients(live *liveConfig) *streamClients {
+	return &streamClients{live: live, active: make(map[string]int), windows: make(map[string]*scrapeWindow)}
+}
+
+// Count a stream request from the client, or explain why it is refused.
+// The caller must call release once the stream is over. Selftest
+// listeners are exempt; they all share the loopback address.
+func (s *streamClients) acquire(c *gin.Context) (release func(), e *limitError) {
+	if _, ok := selftestListener(c); ok {
+		return func() {}, nil
+	}
+	config := s.live.get()
+	ip := c.ClientIP()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if config.StreamRatePerIP > 0 {
+		now := time.Now()
+		w, ok := s.windows[ip]
+		if !ok || now.Sub(w.start) >= time.Minute {
+			for key, old := range s.windows {
+				if now.Sub(old.start) >= time.Minute {
+					delete(s.windows, key)
+				}
+			}
+			w = &scrapeWindow{start: now}
+			s.windows[ip] = w
+		}
+		w.count++
+		if w.count > config.StreamRatePerIP {
+			return nil, &limitError{
+				Limit:      "stream_requests_per_minute",
+				Current:    w.count,
+				Max:        config.StreamRatePerIP,
+				RetryAfter: w.start.Add(time.Minute).Sub(now),
+				PerClient:  true,
+			}
+		}
+	}
+	if config.MaxStreamsPerIP > 0 && s.active[ip] >= config.MaxStreamsPerIP {
+		return nil, &limitError{
+			Limit:      "streams_per_client",
+			Current:    s.active[ip],
+			Max:        config.MaxStreamsPerIP,
+			RetryAfter: 5 * time.Second,
+			PerClient:  true,
+		}
+	}
+	s.active[ip]++
+	return func() {
+		s.mu.Lock()
+		if s.active[ip]--; s.active[ip] <= 0 {
+			delete(s.active, ip)
+		}
+		s.mu.Unlock()
+	}, nil
+}
+
+// Applies the per-client limits to a stream route. Only mounted on the
+// stream routes, so /metrics and /health are never counted.
+func streamClientLimit(clients *streamClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, e := clients.acquire(c)
+		if e != nil {
+			respondLimit(c, e)
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Read a JSON config file whose keys are flag names, e.g.
+// {"api": "https://...", "cache-ttl": "1m", "probe-stations": true}.
+// Lists may be given as arrays and are joined with commas.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// Keep numbers as written; 1048576 would otherwise come back as
+	// 1.048576e+06, which integer flags reject
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string, len(raw))
+	for key, val := range raw {
+		switch v := val.(type) {
+		case string:
+			settings[key] = v
+		case json.Number:
+			settings[key] = v.String()
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			settings[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			settings[key] = fmt.Sprint(v)
+		}
+	}
+	return settings, nil
+}
+
+// Apply file settings for every flag not given on the command line and
+// return them. Unknown keys are only warned about so a newer file works
+// with an older binary.
+func applyConfigFile(path string, explicit map[string]string) map[string]string {
+	settings, err := loadConfigFile(path)
+	if err != nil {
+		log.Fatalf("Error: failed to load config file: %v", err)
+	}
+	for key, val := range settings {
+		if key == "config" {
+			continue
+		}
+		if flag.Lookup(key) == nil {
+			log.Printf("Warning: unknown config file key %q", key)
+			continue
+		}
+		if _, ok := explicit[key]; ok {
+			continue
+		}
+		if err := flag.Set(key, val); err != nil {
+			log.Fatalf("Error: invalid %s in config file: %v", key, err)
+		}
+	}
+	return settings
+}
+
+// Flags given on the command line, with their values
+func explicitFlags() map[string]string {
+	explicit := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	return explicit
+}
+
+// Restore command-line values over anything the environment changed
+func reapplyFlags(explicit map[string]string) {
+	for name, val := range explicit {
+		flag.Set(name, val)
+	}
+}
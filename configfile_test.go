@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Run parseConfig against a fresh flag set with the given arguments
+func parseTestArgs(t *testing.T, args ...string) Config {
+	t.Helper()
+	t.Setenv("RADIO_API_ENDPOINT", "http://catalog.example/")
+	savedArgs, savedFlags := os.Args, flag.CommandLine
+	t.Cleanup(func() { os.Args, flag.CommandLine = savedArgs, savedFlags })
+	os.Args = append([]string{"radio"}, args...)
+	flag.CommandLine = flag.NewFlagSet("radio", flag.ContinueOnError)
+	return parseConfig()
+}
+
+func TestConfigFileNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "radio.json")
+	if err := os.WriteFile(path, []byte(`{"max-body-bytes": 1048576, "max-api-conns": 0, "cors-origins": ["https://a.example", "https://b.example"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"max-body-bytes": "1048576",
+		"max-api-conns":  "0",
+		"cors-origins":   "https://a.example,https://b.example",
+	} {
+		if settings[key] != want {
+			t.Errorf("%s = %q, want %q", key, settings[key], want)
+		}
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "radio.json")
+	if err := os.WriteFile(path, []byte(`{"max-body-bytes": 1048576, "cache-ttl": "1m"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		config := parseTestArgs(t)
+		if config.MaxBodyBytes != 64*1024 || config.CacheTTL != 30*time.Second {
+			t.Errorf("max body %d, cache TTL %s, want the flag defaults", config.MaxBodyBytes, config.CacheTTL)
+		}
+	})
+	t.Run("file over defaults", func(t *testing.T) {
+		config := parseTestArgs(t, "-config", path)
+		if config.MaxBodyBytes != 1048576 || config.CacheTTL != time.Minute {
+			t.Errorf("max body %d, cache TTL %s, want the file's values", config.MaxBodyBytes, config.CacheTTL)
+		}
+	})
+	t.Run("environment over file", func(t *testing.T) {
+		t.Setenv("RADIO_MAX_BODY_BYTES", "2048")
+		config := parseTestArgs(t, "-config", path)
+		if config.MaxBodyBytes != 2048 || config.CacheTTL != time.Minute {
+			t.Errorf("max body %d, cache TTL %s, want 2048 from the environment and 1m from the file", config.MaxBodyBytes, config.CacheTTL)
+		}
+	})
+	t.Run("flags over environment", func(t *testing.T) {
+		t.Setenv("RADIO_MAX_BODY_BYTES", "2048")
+		t.Setenv("RADIO_CACHE_TTL", "2m")
+		config := parseTestArgs(t, "-config", path, "-max-body-bytes", "4096")
+		if config.MaxBodyBytes != 4096 || config.CacheTTL != 2*time.Minute {
+			t.Errorf("max body %d, cache TTL %s, want 4096 from the flag and 2m from the environment", config.MaxBodyBytes, config.CacheTTL)
+		}
+	})
+}
+
+func TestSSLFromEnvironment(t *testing.T) {
+	t.Setenv("RADIO_SSL_CERT", "/etc/radio/cert.pem")
+	t.Setenv("RADIO_SSL_KEY", "/etc/radio/key.pem")
+	config := parseTestArgs(t)
+	if config.SSLCert != "/etc/radio/cert.pem" || config.SSLKey != "/etc/radio/key.pem" {
+		t.Errorf("cert %q, key %q, want the environment's", config.SSLCert, config.SSLKey)
+	}
+
+	config = parseTestArgs(t, "-cert", "cert.pem", "-key", "key.pem")
+	if config.SSLCert != "cert.pem" || config.SSLKey != "key.pem" {
+		t.Errorf("cert %q, key %q, want the flags'", config.SSLCert, config.SSLKey)
+	}
+}
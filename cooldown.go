@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var stationCooldown = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "radio_station_cooldown",
+		Help: "1 while a station is in cooldown after repeated stream failures",
+	},
+	[]string{"station"},
+)
+
+type stationFailures struct {
+	Count int
+	First time.Time
+	Until time.Time
+}
+
+// Per-station circuit breaker: after Threshold consecutive stream failures
+// within Window the station is refused for Duration without contacting
+// the origin. A successful stream or probe clears it early.
+type cooldownTracker struct {
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+
+	mu       sync.Mutex
+	stations map[string]*stationFailures
+}
+
+func newCooldownTracker(config Config) *cooldownTracker {
+	return &cooldownTracker{
+		threshold: config.CooldownFailures,
+		window:    config.CooldownWindow,
+		duration:  config.CooldownDuration,
+		stations:  make(map[string]*stationFailures),
+	}
+}
+
+// Returns a limitError while the station is cooling down
+func (t *cooldownTracker) check(station RadioStation) *limitError {
+	if t.threshold <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.stations[station.stateKey()]
+	if !ok || f.Until.IsZero() {
+		return nil
+	}
+	remaining := time.Until(f.Until)
+	if remaining <= 0 {
+		t.clearLocked(station)
+		return nil
+	}
+	return &limitError{Limit: "station_cooldown", Current: f.Count, Max: t.threshold, RetryAfter: remaining}
+}
+
+func (t *cooldownTracker) failure(station RadioStation) {
+	if t.threshold <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := station.stateKey()
+	now := time.Now()
+	f, ok := t.stations[key]
+	if !ok || now.Sub(f.First) > t.window {
+		f = &stationFailures{First: now}
+		t.stations[key] = f
+	}
+	f.Count++
+	if f.Count >= t.threshold && f.Until.IsZero() {
+		f.Until = now.Add(t.duration)
+		stationCooldown.WithLabelValues(station.stateLabel()).Set(1)
+	}
+}
+
+func (t *cooldownTracker) success(station RadioStation) {
+	if t.threshold <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clearLocked(station)
+}
+
+func (t *cooldownTracker) clearLocked(station RadioStation) {
+	key := station.stateKey()
+	if f, ok := t.stations[key]; ok && !f.Until.IsZero() {
+		stationCooldown.DeleteLabelValues(station.stateLabel())
+	}
+	delete(t.stations, key)
+}
+
+// Cooldown end time for the admin listing, zero if not cooling down
+func (t *cooldownTracker) until(station RadioStation) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f, ok := t.stations[station.stateKey()]; ok && time.Now().Before(f.Until) {
+		return f.Until
+	}
+	return time.Time{}
+}
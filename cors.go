@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cross-origin rules for one group of routes. A disabled policy sends no
+// CORS headers at all, so browsers refuse cross-origin calls.
+type corsPolicy struct {
+	Enabled bool
+	// Origins allowed to read responses; "*" allows any
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func publicCORSPolicy(config Config) corsPolicy {
+	return corsPolicy{
+		Enabled:          true,
+		AllowOrigins:     config.CORSOrigins,
+		AllowMethods:     config.CORSMethods,
+		AllowHeaders:     config.CORSHeaders,
+		AllowCredentials: config.CORSAllowCredentials,
+		MaxAge:           config.CORSMaxAge,
+	}
+}
+
+func adminCORSPolicy(config Config) corsPolicy {
+	return corsPolicy{
+		Enabled:          config.AdminCORS,
+		AllowOrigins:     config.CORSOrigins,
+		AllowMethods:     []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "X-API-Key"},
+		AllowCredentials: config.CORSAllowCredentials,
+		MaxAge:           config.CORSMaxAge,
+	}
+}
+
+func (p corsPolicy) allowsAnyOrigin() bool {
+	for _, o := range p.AllowOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	for _, o := range p.AllowOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p corsPolicy) apply(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if !p.Enabled || origin == "" {
+		return
+	}
+
+	h := c.Writer.Header()
+	wildcard := p.allowsAnyOrigin()
+	if !wildcard {
+		// The answer depends on the origin, so caches must key on it
+		h.Add("Vary", "Origin")
+	}
+	if !p.allowsOrigin(origin) {
+		return
+	}
+	// Credentialed requests may not use the wildcard, so echo the origin
+	switch {
+	case p.AllowCredentials:
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		if wildcard {
+			h.Add("Vary", "Origin")
+		}
+	case wildcard:
+		h.Set("Access-Control-Allow-Origin", "*")
+	default:
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowMethods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(p.AllowHeaders, ", "))
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+}
+
+// Apply the admin policy under /admin and the public policy everywhere else.
+// Registered on the engine so preflights for unmatched OPTIONS routes are
+// answered too. Policies follow the live config, so reloads apply at once.
+func corsMiddleware(live *liveConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config := live.get()
+		policy := publicCORSPolicy(*config)
+		if strings.HasPrefix(c.Request.URL.Path, "/admin") {
+			policy = adminCORSPolicy(*config)
+		}
+		policy.apply(c)
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
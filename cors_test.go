@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name      string
+		origins   []string
+		origin    string
+		wantAllow string
+		wantVary  bool
+	}{
+		{"wildcard default", []string{"*"}, "https://player.example", "*", false},
+		{"allowed origin", []string{"https://player.example"}, "https://player.example", "https://player.example", true},
+		{"allowed origin in another case", []string{"https://player.example"}, "https://PLAYER.example", "https://PLAYER.example", true},
+		{"disallowed origin", []string{"https://player.example"}, "https://evil.example", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig("http://catalog.example/")
+			config.CORSOrigins = tt.origins
+			r := gin.New()
+			r.Use(corsMiddleware(newLiveConfig(config)))
+			r.GET("/stations", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for _, method := range []string{http.MethodGet, http.MethodOptions} {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(method, "/stations", nil)
+				req.Header.Set("Origin", tt.origin)
+				r.ServeHTTP(w, req)
+
+				if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+					t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", method, got, tt.wantAllow)
+				}
+				if got := w.Header().Get("Vary") == "Origin"; got != tt.wantVary {
+					t.Errorf("%s: Vary: Origin set = %t, want %t", method, got, tt.wantVary)
+				}
+				if tt.wantAllow == "" && w.Header().Get("Access-Control-Allow-Methods") != "" {
+					t.Errorf("%s: methods advertised to a disallowed origin", method)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminCORSIsOffByDefault(t *testing.T) {
+	config := testConfig("http://catalog.example/")
+	r := gin.New()
+	r.Use(corsMiddleware(newLiveConfig(config)))
+	r.GET("/admin/relays", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/relays", nil)
+	req.Header.Set("Origin", "https://player.example")
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q on an admin route", got)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"mime"
+	"strings"
+	"unicode"
+)
+
+// File extensions for ?download=1, by media type
+var downloadExtensions = map[string]string{
+	"audio/mpeg":      ".mp3",
+	"audio/mp3":       ".mp3",
+	"audio/aac":       ".aac",
+	"audio/aacp":      ".aac",
+	"audio/ogg":       ".ogg",
+	"application/ogg": ".ogg",
+	"audio/opus":      ".opus",
+	"audio/flac":      ".flac",
+	"audio/wav":       ".wav",
+}
+
+// Content-Disposition value for saving a station's stream to a file
+func downloadDisposition(station, contentType string) string {
+	ext := ".bin"
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if e, ok := downloadExtensions[strings.ToLower(mediaType)]; ok {
+			ext = e
+		}
+	}
+	return mime.FormatMediaType("attachment", map[string]string{
+		"filename": sanitizeFilename(station) + ext,
+	})
+}
+
+// Keep letters, digits, '-' and '_'; anything else becomes a single '_'
+func sanitizeFilename(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range name {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	filename := strings.TrimRight(b.String(), "_")
+	if filename == "" {
+		return "stream"
+	}
+	return filename
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"expvar"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Mirror key metrics into expvar for tools that read /debug/vars. Values
+// are read from the Prometheus collectors on every request, so nothing is
+// counted twice and the Prometheus registry is left alone.
+func publishExpvars(api *stationsAPI) {
+	expvar.Publish("radio_active_streams", expvar.Func(func() any {
+		return metricValue(activeStreams)
+	}))
+	expvar.Publish("radio_stream_errors_total", expvar.Func(func() any {
+		return metricValue(streamErrors)
+	}))
+	expvar.Publish("radio_api_requests_in_flight", expvar.Func(func() any {
+		return metricValue(apiInFlight)
+	}))
+	expvar.Publish("radio_station_requests_total", expvar.Func(func() any {
+		return collectorValues(stationRequests)
+	}))
+	expvar.Publish("radio_station_cache_age_seconds", expvar.Func(func() any {
+		return api.cache.age()
+	}))
+}
+
+func metricValue(m prometheus.Metric) float64 {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		return 0
+	}
+	switch {
+	case pb.Gauge != nil:
+		return pb.Gauge.GetValue()
+	case pb.Counter != nil:
+		return pb.Counter.GetValue()
+	}
+	return 0
+}
+
+// Values of a single-label metric vector, keyed by label value
+func collectorValues(c prometheus.Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	values := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil || len(pb.Label) == 0 {
+			continue
+		}
+		values[pb.Label[0].GetValue()] = metricValue(m)
+	}
+	return values
+}
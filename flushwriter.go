@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Buffers writes to the client but flushes at least every interval, so a
+// low-bitrate stream doesn't sit in the buffer for seconds before playing
+type periodicFlusher struct {
+	mu      sync.Mutex
+	buf     *bufio.Writer
+	flusher http.Flusher
+	stop    chan struct{}
+
+	// Called once, when audio first reaches the client
+	onFirstByte func()
+	sent        bool
+}
+
+func newPeriodicFlusher(w http.ResponseWriter, size int, interval time.Duration) *periodicFlusher {
+	f := &periodicFlusher{buf: bufio.NewWriterSize(w, size), stop: make(chan struct{})}
+	f.flusher, _ = w.(http.Flusher)
+	if interval > 0 {
+		go f.run(interval)
+	}
+	return f
+}
+
+func (f *periodicFlusher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.Flush()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *periodicFlusher) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	before := f.buf.Buffered()
+	n, err := f.buf.Write(p)
+	// A write larger than the buffer goes straight through
+	if n > 0 && f.buf.Buffered() < before+n {
+		f.markSent()
+	}
+	return n, err
+}
+
+func (f *periodicFlusher) markSent() {
+	if !f.sent {
+		f.sent = true
+		if f.onFirstByte != nil {
+			f.onFirstByte()
+		}
+	}
+}
+
+// Push buffered bytes through to the client
+func (f *periodicFlusher) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.buf.Buffered() == 0 {
+		return nil
+	}
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	f.markSent()
+	return nil
+}
+
+// Stop the ticker and flush what is left
+func (f *periodicFlusher) Close() error {
+	close(f.stop)
+	return f.Flush()
+}
+
+// Copy src to the flusher with an explicitly sized buffer, returning the
+// bytes written even when the copy fails partway
+func copyStream(dst *periodicFlusher, src io.Reader, buf []byte) (int64, error) {
+	var written int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			w, werr := dst.Write(buf[:n])
+			written += int64(w)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
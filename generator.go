@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MPEG-1 Layer III bitrate indexes, by kbps
+var mp3BitrateIndex = map[int]byte{
+	32: 1, 40: 2, 48: 3, 56: 4, 64: 5, 80: 6, 96: 7,
+	112: 8, 128: 9, 160: 10, 192: 11, 224: 12, 256: 13, 320: 14,
+}
+
+const (
+	mp3SampleRate   = 44100
+	mp3FrameSamples = 1152
+	wavSampleRate   = 22050
+)
+
+// A built-in station that needs no upstream: MP3 silence, or WAV silence
+// or a sine test tone, paced in real time
+type virtualStation struct {
+	format  string
+	bitrate int
+	toneHz  int
+}
+
+func newVirtualStation(config Config) *virtualStation {
+	return &virtualStation{
+		format:  config.VirtualFormat,
+		bitrate: config.VirtualBitrate,
+		toneHz:  config.VirtualToneHz,
+	}
+}
+
+func (v *virtualStation) serve(c *gin.Context, config Config) {
+	var (
+		contentType string
+		header      []byte
+		interval    time.Duration
+		next        func() []byte
+	)
+	if v.format == "wav" {
+		contentType, header = "audio/wav", wavStreamHeader()
+		interval = 100 * time.Millisecond
+		next = v.toneChunk(wavSampleRate / 10)
+	} else {
+		frame := silentMP3Frame(v.bitrate)
+		contentType = "audio/mpeg"
+		interval = time.Second * mp3FrameSamples / mp3SampleRate
+		next = func() []byte { return frame }
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Transfer-Encoding", "chunked")
+	if config.StreamConnection != "" && c.Request.ProtoMajor == 1 {
+		c.Header("Connection", config.StreamConnection)
+	}
+	c.Status(http.StatusOK)
+
+	activeStreams.Inc()
+	defer activeStreams.Dec()
+
+	if header != nil {
+		if _, err := c.Writer.Write(header); err != nil {
+			return
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.Writer.Write(next()); err != nil {
+			return
+		}
+		c.Writer.Flush()
+		select {
+		case <-ticker.C:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// An all-zero Layer III frame (mono, 44.1kHz) decodes as silence
+func silentMP3Frame(kbps int) []byte {
+	frame := make([]byte, 144*kbps*1000/mp3SampleRate)
+	frame[0], frame[1] = 0xFF, 0xFB
+	frame[2] = mp3BitrateIndex[kbps] << 4
+	frame[3] = 0xC0
+	return frame
+}
+
+// RIFF header for an open-ended 16-bit mono stream; sizes are left at
+// their maximum since the length is unknown
+func wavStreamHeader() []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 0xFFFFFFFF)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1)
+	binary.LittleEndian.PutUint16(h[22:], 1)
+	binary.LittleEndian.PutUint32(h[24:], wavSampleRate)
+	binary.LittleEndian.PutUint32(h[28:], wavSampleRate*2)
+	binary.LittleEndian.PutUint16(h[32:], 2)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], 0xFFFFFFFF)
+	return h
+}
+
+// Successive chunks of a sine wave at half scale, or silence. The phase
+// counter wraps each second, which is seamless for whole-Hz tones.
+func (v *virtualStation) toneChunk(samples int) func() []byte {
+	var n int
+	return func() []byte {
+		chunk := make([]byte, samples*2)
+		if v.toneHz > 0 {
+			for i := 0; i < samples; i++ {
+				s := math.Sin(2 * math.Pi * float64(v.toneHz*n) / wavSampleRate)
+				binary.LittleEndian.PutUint16(chunk[2*i:], uint16(int16(s*16383)))
+				n = (n + 1) % wavSampleRate
+			}
+		}
+		return chunk
+	}
+}
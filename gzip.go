@@ -0,0 +1,99 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Paths whose JSON responses are worth compressing. Audio is already
+// compressed, and buffering it in a gzip writer would delay playback, so
+// /stream, /hls and /ws are never listed here.
+var gzipPrefixes = []string{"/stations", "/health", "/nowplaying"}
+
+func gzipPath(path string) bool {
+	for _, prefix := range []string{"/stream/", "/hls/", "/ws/"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	for _, prefix := range gzipPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether the client accepts gzip, honoring an explicit q=0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		if val, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(val, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// Compresses the body on first write, so responses without one (HEAD,
+// 204, 304) go out untouched
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipWriter) start() {
+	if w.gz != nil {
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	// The length of the uncompressed body no longer applies
+	h.Del("Content-Length")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	w.start()
+	return w.gz.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Gzip JSON responses on gzipPath routes for clients that accept it
+func gzipJSON() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || !gzipPath(c.Request.URL.Path) || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+		w := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			if w.gz != nil {
+				w.gz.Close()
+			}
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
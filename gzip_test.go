@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGzipSkipsAudioPaths(t *testing.T) {
+	r := gin.New()
+	r.Use(gzipJSON())
+	r.GET("/stations", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"stations": []string{}}) })
+	audio := func(c *gin.Context) { c.Data(http.StatusOK, "audio/mpeg", []byte{0xFF, 0xFB, 0x90, 0x64}) }
+	r.GET("/stream/:station", audio)
+	r.GET("/stream/id/:id", audio)
+	r.GET("/hls/:station/:file", audio)
+	r.GET("/ws/:station", audio)
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"/stations", "gzip, deflate", true},
+		{"/stations", "gzip;q=0", false},
+		{"/stations", "", false},
+		{"/stream/Jazz", "gzip", false},
+		{"/stream/id/1", "gzip", false},
+		{"/hls/Jazz/playlist.m3u8", "gzip", false},
+		{"/ws/Jazz", "gzip", false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		r.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+			t.Errorf("GET %s with Accept-Encoding %q: gzipped = %t, want %t", tt.path, tt.acceptEncoding, got, tt.wantGzip)
+		}
+	}
+}
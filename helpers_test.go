@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+var testLogger = log.New(io.Discard, "", 0)
+
+// Config holding the flag defaults handlers rely on. Loopback is allowed
+// for streams since every test upstream listens on 127.0.0.1.
+func testConfig(api string) Config {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	config := Config{
+		APIEndpoint:           api,
+		Port:                  "8080",
+		OverrideTTL:           time.Hour,
+		APIQueueTimeout:       5 * time.Second,
+		MetadataTimeout:       10 * time.Second,
+		RejectHTML:            true,
+		PlaylistMaxDepth:      2,
+		StreamStartTimeout:    8 * time.Second,
+		StreamIdleTimeout:     30 * time.Second,
+		QRSize:                256,
+		QRLevel:               "medium",
+		CORSMaxAge:            10 * time.Minute,
+		CORSOrigins:           []string{"*"},
+		CORSMethods:           []string{"GET", "HEAD", "POST", "OPTIONS"},
+		CORSHeaders:           []string{"Content-Type", "Authorization", "X-API-Key", "Range", "Icy-MetaData"},
+		UpstreamUserAgents:    []string{"ICY/5.0"},
+		UserAgentRotation:     "roundrobin",
+		Schemas:               []fieldMap{defaultFieldMap},
+		StreamAddresses:       newAddressPolicy([]*net.IPNet{loopback}, nil),
+		CatalogMerge:          "first",
+		UpstreamReadBuffer:    32 * 1024,
+		StreamBufferSize:      defaultStreamBufferSize,
+		FlushInterval:         250 * time.Millisecond,
+		LogoMaxBytes:          16 * 1024,
+		MaxLearnedRedirects:   3,
+		MaxBodyBytes:          64 * 1024,
+		ConnectTimeout:        5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		APITimeout:            15 * time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		RelayLinger:           5 * time.Second,
+		RelayBacklog:          64 * 1024,
+		LogFormat:             "text",
+	}
+	config.StreamClient, config.APIClient = newUpstreamClients(config)
+	return config
+}
+
+// Serve stations as a catalog in the default schema
+func catalogServer(t *testing.T, stations ...RadioStation) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stations)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Serve the stream routes the way main wires them
+func streamTestServer(t *testing.T, config Config) *httptest.Server {
+	t.Helper()
+	api := newStationsAPI(config, testLogger)
+	overrides := newOverrideStore(config.OverrideTTL)
+	origins := newOriginLimiter(config)
+	cooldowns := newCooldownTracker(config)
+	userAgents := newUserAgentPool(config)
+	var relay *relayHub
+	if config.Relay {
+		relay = newRelayHub(config, testLogger, origins, userAgents, cooldowns, nil, nil)
+	}
+	sessions := newStreamSessions(config)
+	stream := streamStationHandler(config, testLogger, api, overrides, origins, userAgents, nil, cooldowns, nil, newRedirectLearner(config, testLogger, overrides), sessions, relay)
+
+	r := gin.New()
+	live := newLiveConfig(config)
+	streamCap, streamLimit := streamCapacityLimit(newStreamCapacity(live)), streamClientLimit(newStreamClients(live))
+	r.GET("/stream/:station", streamCap, streamLimit, stream)
+	r.GET("/stream/id/:id", streamCap, streamLimit, stream)
+	r.GET("/ws/:station", streamCap, streamLimit, wsStreamHandler(config, live, testLogger, api, overrides, origins, userAgents, cooldowns, sessions, relay))
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var hlsSegmentName = regexp.MustCompile(`^seg[0-9]+\.ts$`)
+
+// Repackages stations into live HLS with ffmpeg. Each station gets one
+// upstream connection and one ffmpeg process writing a rolling playlist
+// and .ts segments to a temp directory, shared by all of its HLS clients.
+// Sessions stop once nobody has fetched from them for the idle timeout.
+// A session holds one stream slot for its whole life, taken from the
+// client that started it, however many clients poll it.
+type hlsManager struct {
+	config     Config
+	logger     *log.Logger
+	origins    *originLimiter
+	userAgents *userAgentPool
+	root       string
+
+	mu       sync.Mutex
+	sessions map[string]*hlsSession
+}
+
+type hlsSession struct {
+	dir        string
+	cancel     context.CancelFunc
+	done       chan struct{}
+	lastAccess atomic.Int64
+}
+
+func newHLSManager(config Config, logger *log.Logger, origins *originLimiter, userAgents *userAgentPool) (*hlsManager, error) {
+	root, err := os.MkdirTemp("", "radio-hls-")
+	if err != nil {
+		return nil, err
+	}
+	m := &hlsManager{config: config, logger: logger, origins: origins, userAgents: userAgents, root: root, sessions: make(map[string]*hlsSession)}
+	go m.reapIdle()
+	return m, nil
+}
+
+// Start a session for the station, or join one another client started
+// meanwhile. release gives back the stream slots taken for it and is
+// called when the session ends, or at once if it is not needed.
+func (m *hlsManager) session(station RadioStation, release func()) (*hlsSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[station.stateKey()]; ok {
+		release()
+		s.lastAccess.Store(time.Now().UnixNano())
+		return s, nil
+	}
+
+	dir, err := os.MkdirTemp(m.root, "station-")
+	if err != nil {
+		release()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &hlsSession{dir: dir, cancel: cancel, done: make(chan struct{})}
+	s.lastAccess.Store(time.Now().UnixNano())
+	m.sessions[station.stateKey()] = s
+
+	go m.run(ctx, station, s, release)
+	return s, nil
+}
+
+// Find the station's running session and mark it as in use
+func (m *hlsManager) lookup(station RadioStation) (*hlsSession, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[station.stateKey()]
+	if ok {
+		s.lastAccess.Store(time.Now().UnixNano())
+	}
+	return s, ok
+}
+
+func (m *hlsManager) run(ctx context.Context, station RadioStation, s *hlsSession, release func()) {
+	defer release()
+	defer func() {
+		m.mu.Lock()
+		if m.sessions[station.stateKey()] == s {
+			delete(m.sessions, station.stateKey())
+		}
+		m.mu.Unlock()
+		os.RemoveAll(s.dir)
+		close(s.done)
+	}()
+	defer s.cancel()
+
+	// ffmpeg can't strip ICY metadata, so don't ask for it
+	resp, release, err := dialUpstream(ctx, m.config, m.origins, m.userAgents, station.URL, false, nil)
+	if err != nil {
+		m.logger.Printf("HLS upstream for %s failed: %v", station.Name, err)
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	segment := strconv.Itoa(int(m.config.HLSSegmentDuration.Seconds()))
+	cmd := exec.CommandContext(ctx, m.config.FFmpegPath, "-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0", "-c:a", "copy", "-f", "hls",
+		"-hls_time", segment,
+		"-hls_list_size", strconv.Itoa(m.config.HLSWindow),
+		"-hls_flags", "delete_segments+omit_endlist",
+		"-hls_segment_filename", filepath.Join(s.dir, "seg%d.ts"),
+		filepath.Join(s.dir, "playlist.m3u8"))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		m.logger.Printf("HLS for %s: %v", station.Name, err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		m.logger.Printf("HLS segmenter for %s failed to start: %v", station.Name, err)
+		return
+	}
+	m.logger.Printf("HLS session started for %s", station.Name)
+
+	io.Copy(stdin, resp.Body)
+	stdin.Close()
+	cmd.Wait()
+	m.logger.Printf("HLS session ended for %s", station.Name)
+}
+
+func (m *hlsManager) reapIdle() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		for _, s := range m.sessions {
+			if time.Since(time.Unix(0, s.lastAccess.Load())) > m.config.HLSIdleTimeout {
+				s.cancel()
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Wait for ffmpeg to write the first playlist
+func (s *hlsSession) waitPlaylist(ctx context.Context, timeout time.Duration) (string, bool) {
+	path := filepath.Join(s.dir, "playlist.m3u8")
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return "", false
+		case <-deadline.C:
+			return "", false
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// Serve /hls/:station/playlist.m3u8 and the segments it lists. The stream
+// limits and URL resolution only apply when a playlist request has to
+// start a session; polls and segment fetches just read its files.
+func hlsHandler(config Config, logger *log.Logger, api *stationsAPI, overrides *overrideStore, hls *hlsManager, capacity *streamCapacity, clients *streamClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		file := c.Param("file")
+		if file != "playlist.m3u8" && !hlsSegmentName.MatchString(file) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		station, found := api.findStation(stations, c.Param("station"))
+		if !found {
+			api.respondStationNotFound(c, stations, c.Param("station"))
+			return
+		}
+
+		if file != "playlist.m3u8" {
+			s, ok := hls.lookup(station)
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Segment not found"})
+				return
+			}
+			c.Header("Content-Type", "video/mp2t")
+			c.File(filepath.Join(s.dir, file))
+			return
+		}
+
+		s, ok := hls.lookup(station)
+		if !ok {
+			if s, ok = startHLSSession(c, config, logger, overrides, hls, capacity, clients, station); !ok {
+				return
+			}
+		}
+		path, ok := s.waitPlaylist(c.Request.Context(), 2*config.HLSSegmentDuration+5*time.Second)
+		if !ok {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Timed out waiting for HLS playlist"})
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read playlist: %v", err)})
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "application/vnd.apple.mpegurl", data)
+	}
+}
+
+// Take the stream slots for a new session, resolve the station's URL and
+// start it. False means an error response has been written.
+func startHLSSession(c *gin.Context, config Config, logger *log.Logger, overrides *overrideStore, hls *hlsManager, capacity *streamCapacity, clients *streamClients, station RadioStation) (*hlsSession, bool) {
+	releaseSlot, e := capacity.acquire()
+	if e != nil {
+		respondLimit(c, e)
+		return nil, false
+	}
+	releaseClient, e := clients.acquire(c)
+	if e != nil {
+		releaseSlot()
+		respondLimit(c, e)
+		return nil, false
+	}
+	release := func() {
+		releaseClient()
+		releaseSlot()
+	}
+
+	if o, ok := overrides.lookup(station); ok {
+		station.URL = o.URL
+	}
+	var err error
+	station.URL, err = resolveStationURL(c.Request.Context(), config, station.URL)
+	if isStationURLError(err) {
+		release()
+		respondStationURLError(c, logger, station, err)
+		return nil, false
+	}
+	if err != nil {
+		release()
+		logger.Printf("Playlist resolution for %s failed: %v", station.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resolve station playlist"})
+		return nil, false
+	}
+
+	s, err := hls.session(station, release)
+	if err != nil {
+		logger.Printf("HLS session for %s failed: %v", station.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start HLS session"})
+		return nil, false
+	}
+	return s, true
+}
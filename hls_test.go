@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stands in for ffmpeg: writes a playlist to its last argument and
+// swallows the stream
+const fakeSegmenter = `#!/bin/sh
+for last; do :; done
+echo '#EXTM3U' > "$last"
+exec cat > /dev/null
+`
+
+func TestHLSLimitsApplyPerSession(t *testing.T) {
+	ffmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte(fakeSegmenter), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	upstream := endlessUpstream(t)
+	catalog := catalogServer(t,
+		RadioStation{ID: 1, Name: "Jazz", URL: upstream.URL + "/jazz"},
+		RadioStation{ID: 2, Name: "Rock", URL: upstream.URL + "/rock"},
+	)
+	config := testConfig(catalog.URL)
+	config.HLS = true
+	config.HLSSegmentDuration = time.Second
+	config.HLSWindow = 2
+	config.HLSIdleTimeout = time.Minute
+	config.FFmpegPath = ffmpeg
+	config.MaxStreamsPerIP = 1
+	config.StreamRatePerIP = 2
+
+	hls, err := newHLSManager(config, testLogger, newOriginLimiter(config), newUserAgentPool(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		hls.mu.Lock()
+		sessions := hls.sessions
+		hls.mu.Unlock()
+		for _, s := range sessions {
+			s.cancel()
+			<-s.done
+		}
+		os.RemoveAll(hls.root)
+	})
+	live := newLiveConfig(config)
+	r := gin.New()
+	r.GET("/hls/:station/:file", hlsHandler(config, testLogger, newStationsAPI(config, testLogger), newOverrideStore(config.OverrideTTL), hls, newStreamCapacity(live), newStreamClients(live)))
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	get := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Polls beyond the per-IP rate reuse the session and its slot
+	for i := 0; i < 5; i++ {
+		if status := get("/hls/Jazz/playlist.m3u8"); status != http.StatusOK {
+			t.Fatalf("poll %d: status = %d, want 200", i, status)
+		}
+	}
+	if status := get("/hls/Rock/playlist.m3u8"); status != http.StatusTooManyRequests {
+		t.Errorf("second session from the same client: status = %d, want 429", status)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Build the clients used for upstream requests. Both use the same settings,
+// so connect and response-header timeouts apply everywhere:
+//   - connect timeout (default 5s) bounds TCP/TLS setup
+//   - response-header timeout (default 10s) fails fast on a silent origin
+//   - idle pool (default 100 conns, 90s) is reused across catalog fetches
+//
+// The stream client has no overall timeout since streams are long-lived;
+// the API client bounds a whole catalog fetch (default 15s). Station URLs
+// come from the catalog, so the stream client's dialer also refuses
+// internal addresses per config.StreamAddresses, and redirects may only
+// lead to http(s) URLs.
+//
+// Each client can go through its own outbound proxy; without one the
+// usual HTTP_PROXY/HTTPS_PROXY environment applies. A proxy dials the
+// station itself, so proxied station requests have the station's DNS
+// checked before they are sent instead, and the proxy may be on an
+// internal address.
+func newUpstreamClients(config Config) (stream, api *http.Client) {
+	dialer := &net.Dialer{Timeout: config.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		MaxIdleConns:          config.MaxIdleConns,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		ForceAttemptHTTP2:     true,
+	}
+	streamDialer := *dialer
+	streamDialer.Control = config.StreamAddresses.control
+	streamTransport := transport.Clone()
+	// Proxies handed out for station requests, which skip the dial guard
+	var proxies sync.Map
+	proxy := proxyFor(config.UpstreamProxy)
+	streamTransport.Proxy = func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if err != nil || u == nil {
+			return u, err
+		}
+		if err := config.StreamAddresses.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		proxies.Store(proxyAddr(u), true)
+		return u, nil
+	}
+	streamTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if _, ok := proxies.Load(addr); ok {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return streamDialer.DialContext(ctx, network, addr)
+	}
+	transport.Proxy = proxyFor(config.CatalogProxy)
+	stream = &http.Client{Transport: streamTransport, CheckRedirect: checkStationRedirect}
+	return stream, &http.Client{Transport: transport, Timeout: config.APITimeout}
+}
+
+func proxyFor(u *url.URL) func(*http.Request) (*url.URL, error) {
+	if u == nil {
+		return http.ProxyFromEnvironment
+	}
+	return http.ProxyURL(u)
+}
+
+// Parse an outbound proxy URL; http, https and socks5 are supported
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return u, nil
+}
+
+// The address dialed to reach a proxy, with the scheme's default port
+func proxyAddr(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	ports := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}
+	return net.JoinHostPort(u.Hostname(), ports[u.Scheme])
+}
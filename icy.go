@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Upper bound on audio-bytes-between-metadata we are willing to skip
+const maxICYMetaint = 1 << 20
+
+// Return the icy-metaint interval announced by the upstream, or 0 if absent
+func icyMetaint(resp *http.Response) int {
+	metaint, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("icy-metaint")))
+	if err != nil || metaint <= 0 || metaint > maxICYMetaint {
+		return 0
+	}
+	return metaint
+}
+
+// Skip audio blocks until the first non-empty ICY metadata block and return
+// its raw contents. Servers send zero-length blocks while the title is unchanged.
+func readICYMetadata(r io.Reader, metaint int) (string, error) {
+	br := bufio.NewReader(r)
+	for {
+		if _, err := io.CopyN(io.Discard, br, int64(metaint)); err != nil {
+			return "", err
+		}
+		length, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if length == 0 {
+			continue
+		}
+
+		block := make([]byte, int(length)*16)
+		if _, err := io.ReadFull(br, block); err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(block), "\x00"), nil
+	}
+}
+
+// Extract StreamTitle='...' from an ICY metadata block
+func parseStreamTitle(meta string) (string, bool) {
+	const prefix = "StreamTitle='"
+	start := strings.Index(meta, prefix)
+	if start < 0 {
+		return "", false
+	}
+	rest := meta[start+len(prefix):]
+	// Titles may contain apostrophes, so look for the field terminator
+	end := strings.Index(rest, "';")
+	if end < 0 {
+		end = strings.LastIndex(rest, "'")
+	}
+	if end < 0 {
+		return "", false
+	}
+	return rest[:end], true
+}
+
+// Collect the icy-* response headers
+func icyHeaders(resp *http.Response) map[string]string {
+	headers := make(map[string]string)
+	for key := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(key), "icy-") {
+			headers[strings.ToLower(key)] = resp.Header.Get(key)
+		}
+	}
+	return headers
+}
+
+// Connect to a stream with Icy-MetaData enabled and read its first title.
+// The upstream connection is closed as soon as one metadata block is read.
+func fetchStreamTitle(ctx context.Context, client *http.Client, streamURL, userAgent string) (*http.Response, *string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", streamURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Icy-MetaData", "1")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	metaint := icyMetaint(resp)
+	if resp.StatusCode >= 400 || metaint == 0 {
+		return resp, nil, nil
+	}
+
+	meta, err := readICYMetadata(resp.Body, metaint)
+	if err != nil {
+		return resp, nil, err
+	}
+	if title, ok := parseStreamTitle(meta); ok {
+		return resp, &title, nil
+	}
+	return resp, nil, nil
+}
+
+type testMetadataRequest struct {
+	URL string `json:"url"`
+}
+
+// Diagnose metadata extraction for an arbitrary stream URL before it is
+// added to the catalog
+func testMetadataHandler(config Config, logger *log.Logger) gin.HandlerFunc {
+	client := newGuardedClient(config.MetadataTimeout)
+
+	return func(c *gin.Context) {
+		var req testMetadataRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			if respondBodyTooLarge(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		if _, err := validateOutboundURL(req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL: " + err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.MetadataTimeout)
+		defer cancel()
+
+		resp, title, err := fetchStreamTitle(ctx, client, req.URL, primaryUserAgent(config))
+		if resp == nil {
+			logger.Printf("Metadata test for %s failed: %v", req.URL, err)
+			if errors.Is(err, errBlockedAddress) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "URL resolves to a blocked address"})
+				return
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to stream"})
+			return
+		}
+
+		result := gin.H{
+			"url":     req.URL,
+			"status":  resp.StatusCode,
+			"metaint": nil,
+			"title":   title,
+			"headers": icyHeaders(resp),
+		}
+		if metaint := icyMetaint(resp); metaint > 0 {
+			result["metaint"] = metaint
+		}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// Report a station's current title from its first ICY metadata block
+func nowPlayingHandler(config Config, logger *log.Logger, api *stationsAPI, overrides *overrideStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stationName := c.Param("station")
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		station, found := api.findStation(stations, stationName)
+		if !found {
+			api.respondStationNotFound(c, stations, stationName)
+			return
+		}
+		if o, ok := overrides.lookup(station); ok {
+			station.URL = o.URL
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.MetadataTimeout)
+		defer cancel()
+
+		streamURL, err := resolveStationURL(ctx, config, station.URL)
+		if isStationURLError(err) {
+			respondStationURLError(c, logger, station, err)
+			return
+		}
+		if err != nil {
+			logger.Printf("Playlist resolution for %s failed: %v", station.Name, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resolve station playlist"})
+			return
+		}
+
+		resp, title, err := fetchStreamTitle(ctx, config.StreamClient, streamURL, primaryUserAgent(config))
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Timed out waiting for stream metadata"})
+			return
+		}
+		if isStationURLError(err) {
+			respondStationURLError(c, logger, station, err)
+			return
+		}
+		if resp == nil {
+			logger.Printf("Now playing for %s failed: %v", station.Name, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to stream"})
+			return
+		}
+		if err != nil {
+			logger.Printf("Reading metadata for %s failed: %v", station.Name, err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"station": station.Name, "title": title})
+	}
+}
+
+// Removes ICY metadata blocks from a stream, passing each block to onMeta
+// and returning only the audio
+type icyStripper struct {
+	r         *bufio.Reader
+	metaint   int
+	remaining int
+	onMeta    func(string)
+}
+
+func newICYStripper(r io.Reader, metaint int, onMeta func(string)) *icyStripper {
+	return &icyStripper{r: bufio.NewReader(r), metaint: metaint, remaining: metaint, onMeta: onMeta}
+}
+
+func (s *icyStripper) Read(p []byte) (int, error) {
+	if s.remaining == 0 {
+		length, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if length > 0 {
+			block := make([]byte, int(length)*16)
+			if _, err := io.ReadFull(s.r, block); err != nil {
+				return 0, err
+			}
+			s.onMeta(strings.TrimRight(string(block), "\x00"))
+		}
+		s.remaining = s.metaint
+	}
+	if len(p) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.r.Read(p)
+	s.remaining -= n
+	return n, err
+}
+
+// Interleaves ICY metadata into audio for clients that sent
+// Icy-MetaData: 1. A block is written after every metaint audio bytes,
+// empty unless the title changed since the last one sent.
+type icyInjector struct {
+	w         io.Writer
+	metaint   int
+	remaining int
+	title     func() string
+	sent      string
+}
+
+func newICYInjector(w io.Writer, metaint int, title func() string) *icyInjector {
+	return &icyInjector{w: w, metaint: metaint, remaining: metaint, title: title}
+}
+
+// Write audio, returning how many audio bytes were written
+func (inj *icyInjector) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), inj.remaining)]
+		n, err := inj.w.Write(chunk)
+		written += n
+		inj.remaining -= n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		if inj.remaining == 0 {
+			if _, err := inj.w.Write(inj.block()); err != nil {
+				return written, err
+			}
+			inj.remaining = inj.metaint
+		}
+	}
+	return written, nil
+}
+
+// The next metadata block: a length byte in 16-byte units, then the
+// NUL-padded StreamTitle field
+func (inj *icyInjector) block() []byte {
+	title := inj.title()
+	if title == inj.sent {
+		return []byte{0}
+	}
+	inj.sent = title
+	meta := "StreamTitle='" + title + "';"
+	if len(meta) > 255*16 {
+		meta = meta[:255*16]
+	}
+	units := (len(meta) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], meta)
+	return block
+}
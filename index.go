@@ -0,0 +1,64 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed assets/favicon.ico
+var favicon []byte
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{"segment": stationPathSegment}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Radio stations</title>
+<link rel="icon" href="/favicon.ico">
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+li { margin: 0.4em 0; }
+small a { color: #666; }
+</style>
+</head>
+<body>
+<h1>Radio stations</h1>
+{{if .}}<ul>
+{{range .}}{{$id := segment .}}<li><a href="/stream/{{$id}}">{{.Name}}</a> <small><a href="/playlist/{{$id}}.m3u">m3u</a></small></li>
+{{end}}</ul>
+{{else}}<p>No stations are available right now.</p>
+{{end}}</body>
+</html>
+`))
+
+// A browsable list of stations for people who open the server root
+func indexHandler(api *stationsAPI, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		sorted := append([]RadioStation(nil), stations...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+		})
+
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := indexTemplate.Execute(c.Writer, sorted); err != nil {
+			logger.Printf("Rendering index failed: %v", err)
+		}
+	}
+}
+
+func faviconHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/x-icon", favicon)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Returned when a capacity limit turns a request away. PerClient limits
+// are the caller's fault (429); everything else is server capacity (503).
+type limitError struct {
+	Limit      string
+	Current    int
+	Max        int
+	RetryAfter time.Duration
+	PerClient  bool
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%s limit reached (%d/%d)", e.Limit, e.Current, e.Max)
+}
+
+// Write the standard rejection response for a capacity limit
+func respondLimit(c *gin.Context, e *limitError) {
+	status := http.StatusServiceUnavailable
+	if e.PerClient {
+		status = http.StatusTooManyRequests
+	}
+	retryAfter := int(math.Ceil(e.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":       e.Error(),
+		"limit":       e.Limit,
+		"current":     e.Current,
+		"max":         e.Max,
+		"retry_after": retryAfter,
+	})
+}
+
+// Cap request bodies on write endpoints; GET streams are left alone
+func limitRequestBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body != nil && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		}
+	}
+}
+
+// Respond 413 if err came from an oversized body
+func respondBodyTooLarge(c *gin.Context, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large", "max": tooLarge.Limit})
+	return true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOversizedBodiesAreRejected(t *testing.T) {
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Jazz", URL: "http://jazz.example/live"})
+	api := newStationsAPI(testConfig(catalog.URL), testLogger)
+
+	r := gin.New()
+	r.Use(limitRequestBody(1024))
+	r.POST("/stations/resolve", resolveStationsHandler(api, testLogger, nil))
+	r.POST("/admin/override", setOverrideHandler(newOverrideStore(0), testLogger))
+
+	small := `["Jazz"]`
+	large := `["` + strings.Repeat("x", 2048) + `"]`
+	tests := []struct {
+		path string
+		body string
+		want int
+	}{
+		{"/stations/resolve", small, http.StatusOK},
+		{"/stations/resolve", large, http.StatusRequestEntityTooLarge},
+		{"/admin/override", `{"station": "` + strings.Repeat("x", 2048) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("POST %s with %d bytes: status = %d, want %d", tt.path, len(tt.body), w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Context keys holding the request ID and when the request arrived
+const (
+	requestIDKey    = "request_id"
+	requestStartKey = "request_start"
+)
+
+// Minimum level of the slog handlers; a reload may change it
+var logLevel = new(slog.LevelVar)
+
+// Set up the process loggers. In json mode the *log.Logger handed to
+// handlers writes through slog, so every existing Printf becomes a JSON
+// record at info level; text mode keeps the original plain output.
+func newLoggers(config Config) *log.Logger {
+	logLevel.Set(config.LogLevel)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if config.LogFormat == "text" {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, opts)))
+		return log.New(os.Stdout, "[Radio-API] ", log.LstdFlags)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, opts)))
+	return slog.NewLogLogger(slog.Default().Handler(), slog.LevelInfo)
+}
+
+// Tag each request with an ID, reusing a sane one from the caller
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Set(requestStartKey, time.Now())
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Access log through slog, so it follows -log-format like everything else
+func accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		slog.Info("request",
+			"request_id", c.GetString(requestIDKey),
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"client_ip", c.ClientIP(),
+			"duration", time.Since(start).Seconds(),
+		)
+	}
+}
+
+// Record the time from the request arriving to its first audio byte
+// reaching the client
+func recordStreamTTFB(c *gin.Context, station string) {
+	if start, ok := c.Get(requestStartKey); ok {
+		streamTTFB.WithLabelValues(station).Observe(time.Since(start.(time.Time)).Seconds())
+	}
+}
+
+// Record a finished stream in the metrics and log what it delivered
+func recordStreamEnd(c *gin.Context, station, upstreamURL string, bytes int64, started time.Time) {
+	streamBytes.WithLabelValues(station).Add(float64(bytes))
+	streamDuration.Observe(time.Since(started).Seconds())
+	slog.Info("stream finished",
+		"request_id", c.GetString(requestIDKey),
+		"station", station,
+		"client_ip", c.ClientIP(),
+		"upstream_url", upstreamURL,
+		"bytes", bytes,
+		"duration", time.Since(started).Seconds(),
+	)
+}
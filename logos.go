@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a fetched (or rejected) logo is reused
+const logoCacheTTL = time.Hour
+
+type cachedLogo struct {
+	dataURI   string
+	fetchedAt time.Time
+}
+
+// Fetches station logos and keeps them as base64 data URIs so
+// /stations?embed_logos=1 can inline them. Logos over the size cap, or that
+// fail to load, are cached as empty so they aren't retried on every request.
+type logoCache struct {
+	logger   *log.Logger
+	client   *http.Client
+	maxBytes int64
+
+	mu    sync.Mutex
+	logos map[string]cachedLogo
+}
+
+func newLogoCache(config Config, logger *log.Logger) *logoCache {
+	return &logoCache{
+		logger:   logger,
+		client:   &http.Client{Transport: config.StreamClient.Transport, Timeout: 5 * time.Second},
+		maxBytes: int64(config.LogoMaxBytes),
+		logos:    make(map[string]cachedLogo),
+	}
+}
+
+// Data URIs for the given logo URLs, fetching up to 8 at a time
+func (l *logoCache) embed(urls []string) map[string]string {
+	result := make(map[string]string)
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		slots = make(chan struct{}, 8)
+	)
+	for _, u := range urls {
+		if u == "" {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(u string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if uri := l.get(u); uri != "" {
+				mu.Lock()
+				result[u] = uri
+				mu.Unlock()
+			}
+		}(u)
+	}
+	wg.Wait()
+	return result
+}
+
+func (l *logoCache) get(logoURL string) string {
+	l.mu.Lock()
+	cached, ok := l.logos[logoURL]
+	l.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < logoCacheTTL {
+		return cached.dataURI
+	}
+
+	uri, err := l.fetch(logoURL)
+	if err != nil {
+		l.logger.Printf("Skipping logo %s: %v", logoURL, err)
+	}
+	l.mu.Lock()
+	l.logos[logoURL] = cachedLogo{dataURI: uri, fetchedAt: time.Now()}
+	l.mu.Unlock()
+	return uri
+}
+
+func (l *logoCache) fetch(logoURL string) (string, error) {
+	resp, err := l.client.Get(logoURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, l.maxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > l.maxBytes {
+		return "", fmt.Errorf("larger than %d bytes", l.maxBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("not an image (%s)", contentType)
+	}
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = strings.TrimSpace(contentType[:i])
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -1,264 +1,1737 @@
 package main
 
 import (
-    "encoding/json"
-    "flag"
-    "fmt"
-    "io"
-    "log"
-    "net/http"
-    "os"
-    "strings"
-    "time"
-
-    "github.com/gin-gonic/gin"
-    "github.com/prometheus/client_golang/prometheus"
-    "github.com/prometheus/client_golang/prometheus/promauto"
-    "github.com/prometheus/client_golang/prometheus/promhttp"
+	"bufio"
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Config struct {
-    APIEndpoint  string
-    Port         string
-    SSLCert     string
-    SSLKey      string
-    EnableHTTPS bool
+	APIEndpoint string
+	SourcesFile string
+	Sources     []stationSource
+	Port        string
+	SSLCert     string
+	SSLKey      string
+	EnableHTTPS bool
+	OverrideTTL time.Duration
+
+	MaxAPIConns     int
+	APIQueueTimeout time.Duration
+
+	ProbeStations     bool
+	ProbeInterval     time.Duration
+	ProbeTimeout      time.Duration
+	ProbeConcurrency  int
+	ProbeOriginJitter time.Duration
+
+	MaxConnsPerOrigin  int
+	OriginQueueTimeout time.Duration
+
+	MetadataTimeout time.Duration
+
+	TrimNames     bool
+	CollapseNames bool
+
+	RejectHTML bool
+
+	// Upper bound on the random delay before connecting to an origin. It is
+	// waited out before any upstream timeout starts, so it adds directly to
+	// a listener's time-to-first-byte; keep it well under client timeouts.
+	ConnectJitter time.Duration
+
+	PlaylistMaxDepth int
+
+	CatalogProbeInterval time.Duration
+
+	StreamStartTimeout time.Duration
+	StreamIdleTimeout  time.Duration
+
+	QRSize  int
+	QRLevel string
+
+	// Connection header sent on /stream responses: "" leaves it to net/http,
+	// "close" or "keep-alive" force it. HTTP/2 has no Connection header, so
+	// the setting only applies to HTTP/1.x clients.
+	StreamConnection string
+
+	CORSMaxAge           time.Duration
+	CORSAllowCredentials bool
+	AdminCORS            bool
+	CORSOrigins          []string
+	CORSMethods          []string
+	CORSHeaders          []string
+
+	UpstreamUserAgents []string
+	UserAgentRotation  string
+	// Client request headers copied onto dedicated upstream connections
+	ForwardHeaders []string
+
+	AudioLevels bool
+	FFmpegPath  string
+
+	CaseInsensitiveRoutes bool
+
+	// Catalog field mappings to try in order: primary, then fallback
+	Schemas []fieldMap
+
+	EnableExpvar bool
+
+	// Consecutive failures within CooldownWindow before a station is
+	// refused for CooldownDuration; 0 disables
+	CooldownFailures int
+	CooldownWindow   time.Duration
+	CooldownDuration time.Duration
+
+	// Successful but slow upstream responses are logged past these; 0 disables
+	SlowTTFBThreshold    time.Duration
+	SlowCatalogThreshold time.Duration
+
+	// Built-in test station served without an upstream; empty disables
+	VirtualStation string
+	VirtualFormat  string
+	VirtualBitrate int
+	VirtualToneHz  int
+
+	// Optional /metrics restrictions; unrestricted by default
+	MetricsAllowCIDRs []*net.IPNet
+	MetricsRateLimit  int
+
+	// Networks station streams may or may not reach on top of the
+	// built-in private/loopback/link-local block
+	StreamAddresses *addressPolicy
+
+	// Server-wide cap on simultaneous streams (0 = unlimited)
+	MaxActiveStreams int
+
+	// Per-client stream limits (0 = unlimited) and the proxies whose
+	// X-Forwarded-For identifies the client
+	MaxStreamsPerIP int
+	StreamRatePerIP int
+	TrustedProxies  []string
+
+	// Optional URL that receives catalog added/removed events
+	CatalogWebhook string
+	// Which catalog source wins a duplicate station: first or last
+	CatalogMerge string
+
+	// Size of reads from the upstream body
+	UpstreamReadBuffer int
+	// Size of the client write buffer and of each copy between the two
+	StreamBufferSize int
+	// Longest audio may sit in the write buffer (0 = flush only when full)
+	FlushInterval time.Duration
+
+	// Largest logo inlined by /stations?embed_logos=1
+	LogoMaxBytes int
+
+	// Store 301 targets as station overrides, at most MaxLearnedRedirects
+	// times per station
+	LearnRedirects      bool
+	MaxLearnedRedirects int
+
+	// Largest request body accepted by POST/DELETE endpoints
+	MaxBodyBytes int
+
+	// What to do when a client opens a second stream of the same station:
+	// "" (allow), reject or takeover
+	DuplicateStreams string
+
+	// Enables POST /admin/selftest, which generates real streaming load
+	Selftest bool
+
+	// Check the catalog (and probe this many stations) then exit
+	Validate      bool
+	ValidateProbe int
+
+	// Include close station name matches in 404 responses
+	SuggestStations bool
+
+	// How long the merged station catalog is reused; 0 disables caching
+	CacheTTL time.Duration
+
+	// How long active streams may continue after SIGINT/SIGTERM
+	ShutdownTimeout time.Duration
+
+	// Upstream HTTP client settings; see newUpstreamClients
+	ConnectTimeout        time.Duration
+	ResponseHeaderTimeout time.Duration
+	APITimeout            time.Duration
+	MaxIdleConns          int
+	IdleConnTimeout       time.Duration
+	StreamClient          *http.Client
+	APIClient             *http.Client
+	// Base that relative station URLs are resolved against
+	StationBaseURL *url.URL
+	// Outbound proxies for stream and catalog connections (nil = environment)
+	UpstreamProxy *url.URL
+	CatalogProxy  *url.URL
+
+	// Extra connection attempts for a stream, StreamRetryDelay apart and
+	// doubling each time
+	StreamRetries    int
+	StreamRetryDelay time.Duration
+
+	// Live HLS repackaging with ffmpeg
+	HLS                bool
+	HLSSegmentDuration time.Duration
+	HLSWindow          int
+	HLSIdleTimeout     time.Duration
+
+	// Share one upstream connection per station among its listeners
+	Relay        bool
+	RelayLinger  time.Duration
+	RelayBacklog int
+
+	// POST relayed stations' ICY title changes here (needs Relay)
+	ScrobbleWebhook  string
+	ScrobbleDebounce time.Duration
+	// Titles kept per relayed station for /history (0 = off)
+	HistorySize int
+	HistoryIdle time.Duration
+
+	// Profiling endpoints, on a separate listener when PprofAddr is set
+	EnablePprof bool
+	PprofAddr   string
+
+	// Credentials required on all non-exempt routes; unset disables auth
+	APIKey        string
+	BasicUser     string
+	BasicPassword string
+	AuthExempt    []string
+
+	// json (default) or text, the minimum level logged, and whether each
+	// request is logged
+	LogFormat string
+	LogLevel  slog.Level
+	AccessLog bool
+
+	// Where settings came from, for SIGHUP reloads: the -config file and
+	// what it set, and the flags given on the command line
+	ConfigFile    string
+	FileSettings  map[string]string
+	ExplicitFlags map[string]string
 }
 
+const defaultStreamBufferSize = 32 * 1024
+
 type RadioStation struct {
-    ID        int       `json:"id"`
-    CreatedAt time.Time `json:"created_at"`
-    Name      string    `json:"name"`
-    URL       string    `json:"url"`
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+
+	LogoURL string `json:"logo_url,omitempty"`
+	// Comma-separated genres, when the catalog provides them
+	Genre string `json:"genre,omitempty"`
+
+	// URL-safe form of Name, also accepted wherever a name is
+	Slug string `json:"slug,omitempty"`
+	// Another station in the same catalog has the same name
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	// Normalized form of Name used for lookups; Name stays as the display name
+	MatchKey string `json:"-"`
+	// Label of the catalog source the station was loaded from
+	Source string `json:"-"`
+}
+
+// Key for per-station state such as relay feeds and HLS sessions. Equal
+// to MatchKey unless another station shares the name.
+func (s RadioStation) stateKey() string {
+	if !s.Duplicate {
+		return s.MatchKey
+	}
+	if s.ID != 0 {
+		return s.MatchKey + "#" + strconv.Itoa(s.ID)
+	}
+	return s.MatchKey + "#" + s.URL
+}
+
+// Metric label for per-station state: the name, with the state key's
+// suffix when another station shares it
+func (s RadioStation) stateLabel() string {
+	return s.Name + strings.TrimPrefix(s.stateKey(), s.MatchKey)
 }
 
 type StationResponse struct {
-    Name string `json:"name"`
+	Name      string `json:"name"`
+	Slug      string `json:"slug,omitempty"`
+	Available *bool  `json:"available,omitempty"`
+	Logo      string `json:"logo,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+
+	// With ?format=full, or for duplicates so they stay addressable
+	ID          int    `json:"id,omitempty"`
+	StreamURL   string `json:"stream_url,omitempty"`
+	PlaylistURL string `json:"playlist_url,omitempty"`
 }
 
 // Prometheus metrics
 var (
-    stationRequests = promauto.NewCounterVec(
-        prometheus.CounterOpts{
-            Name: "radio_station_requests_total",
-            Help: "The total number of requests per station",
-        },
-        []string{"station"},
-    )
-    
-    apiLatency = promauto.NewHistogramVec(
-        prometheus.HistogramOpts{
-            Name:    "radio_api_latency_seconds",
-            Help:    "The latency of API requests",
-            Buckets: prometheus.DefBuckets,
-        },
-        []string{"endpoint"},
-    )
-    
-    streamErrors = promauto.NewCounter(
-        prometheus.CounterOpts{
-            Name: "radio_stream_errors_total",
-            Help: "The total number of streaming errors",
-        },
-    )
-    
-    activeStreams = promauto.NewGauge(
-        prometheus.GaugeOpts{
-            Name: "radio_active_streams",
-            Help: "The number of currently active streams",
-        },
-    )
+	stationRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "radio_station_requests_total",
+			Help: "The total number of requests per station",
+		},
+		[]string{"station"},
+	)
+
+	apiLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "radio_api_latency_seconds",
+			Help:    "The latency of API requests",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+
+	streamErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "radio_stream_errors_total",
+			Help: "The total number of streaming errors",
+		},
+	)
+
+	// How established streams ended
+	streamClientDisconnects = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "radio_stream_client_disconnects_total",
+			Help: "Streams ended by the listener going away",
+		},
+		[]string{"station"},
+	)
+	streamUpstreamErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "radio_stream_upstream_errors_total",
+			Help: "Streams ended by the upstream failing or stalling",
+		},
+		[]string{"station"},
+	)
+	streamCompletions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "radio_stream_completions_total",
+			Help: "Streams whose upstream ended cleanly",
+		},
+		[]string{"station"},
+	)
+
+	activeStreams = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "radio_active_streams",
+			Help: "The number of currently active streams",
+		},
+	)
+
+	apiInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "radio_api_requests_in_flight",
+			Help: "The number of in-flight requests to the stations API",
+		},
+	)
+
+	catalogFetchLatency = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "radio_upstream_catalog_latency_seconds",
+			Help:    "Time to fetch and decode an upstream station catalog",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	catalogFetchFailures = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "radio_upstream_catalog_failures_total",
+			Help: "Upstream station catalog fetches that failed or could not be decoded",
+		},
+	)
+
+	htmlResponses = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "radio_upstream_html_responses_total",
+			Help: "The total number of stream connects rejected because the upstream sent an HTML page",
+		},
+	)
+
+	connectJitter = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "radio_connect_jitter_seconds",
+			Help:    "Random delay applied before connecting to a stream origin",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	contentTypeFallbacks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "radio_content_type_fallbacks_total",
+			Help: "The total number of streams served as application/octet-stream because the format was unknown",
+		},
+		[]string{"station"},
+	)
+
+	streamBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "radio_stream_bytes_total",
+			Help: "The total number of audio bytes sent to clients",
+		},
+		[]string{"station"},
+	)
+
+	streamTTFB = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "radio_stream_ttfb_seconds",
+			Help:    "Time from a stream request arriving to its first audio byte being flushed to the client",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 30},
+		},
+		[]string{"station"},
+	)
+	streamDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "radio_stream_duration_seconds",
+			Help:    "How long client streams lasted",
+			Buckets: []float64{1, 10, 30, 60, 300, 900, 1800, 3600, 7200, 14400},
+		},
+	)
+)
+
+// Stream starts by codec, to see which formats listeners actually get
+var streamCodecs = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radio_stream_codec_starts_total",
+		Help: "Streams started, by detected codec (mp3, aac, ogg, opus, flac, wav or other)",
+	},
+	[]string{"codec"},
 )
 
-func getEnv(key, fallback string) string {
-    if value, exists := os.LookupEnv(key); exists {
-        return value
-    }
-    return fallback
+// Warn about undetectable content types at most once per station per interval
+var contentTypeWarnings = newLogThrottle(10 * time.Minute)
+
+// Override a duration setting from the environment, exiting on a malformed value
+func envDuration(key string, target *time.Duration) {
+	if val := os.Getenv(key); val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			log.Fatalf("Error: invalid %s: %v", key, err)
+		}
+		*target = d
+	}
+}
+
+// Split a comma-separated setting, dropping empty entries
+func splitList(val string) []string {
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Override a boolean setting from the environment, exiting on a malformed value
+func envBool(key string, target *bool) {
+	if val := os.Getenv(key); val != "" {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			log.Fatalf("Error: invalid %s: %v", key, err)
+		}
+		*target = b
+	}
+}
+
+// Override an integer setting from the environment, exiting on a malformed value
+func envInt(key string, target *int) {
+	if val := os.Getenv(key); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			log.Fatalf("Error: invalid %s: %v", key, err)
+		}
+		*target = n
+	}
 }
 
 func parseConfig() Config {
-    var config Config
-    
-    // Command line flags
-    flag.StringVar(&config.APIEndpoint, "api", "", "Radio stations API endpoint")
-    flag.StringVar(&config.Port, "port", "", "Port to listen on")
-    flag.StringVar(&config.SSLCert, "cert", "", "Path to SSL certificate file")
-    flag.StringVar(&config.SSLKey, "key", "", "Path to SSL private key file")
-    
-    flag.Parse()
-    
-    // Environment variables override flags
-    config.APIEndpoint = getEnv("RADIO_API_ENDPOINT", config.APIEndpoint)
-    config.Port = getEnv("RADIO_PORT", config.Port)
-    config.SSLCert = getEnv("RADIO_SSL_CERT", config.SSLCert)
-    config.SSLKey = getEnv("RADIO_SSL_KEY", config.SSLKey)
-    
-    // Set defaults if not provided
-    if config.Port == "" {
-        config.Port = "8080"
-    }
-    
-    if config.APIEndpoint == "" {
-        log.Fatal("Error: API endpoint must be provided via -api flag or RADIO_API_ENDPOINT environment variable")
-    }
-    
-    config.EnableHTTPS = config.SSLCert != "" && config.SSLKey != ""
-    if config.EnableHTTPS && (config.SSLCert == "" || config.SSLKey == "") {
-        log.Fatal("Error: both certificate and key are required for HTTPS")
-    }
-    
-    return config
+	var config Config
+	var userAgents string
+	var schema, fallbackSchema string
+
+	flag.StringVar(&config.APIEndpoint, "api", "", "Radio stations API endpoint; a comma-separated list is fetched concurrently and merged")
+	flag.StringVar(&config.SourcesFile, "sources", "", "JSON file listing station sources to merge in priority order (replaces -api)")
+	flag.StringVar(&config.Port, "port", "8080", "Port to listen on")
+	flag.StringVar(&config.SSLCert, "cert", "", "Path to SSL certificate file")
+	flag.StringVar(&config.SSLKey, "key", "", "Path to SSL private key file")
+	flag.DurationVar(&config.OverrideTTL, "override-ttl", time.Hour, "How long admin station URL overrides stay active")
+	flag.IntVar(&config.MaxAPIConns, "max-api-conns", 0, "Maximum concurrent requests to the stations API (0 = unlimited)")
+	flag.DurationVar(&config.APIQueueTimeout, "api-queue-timeout", 5*time.Second, "How long to wait for a free stations API connection (0 = fail fast)")
+	flag.BoolVar(&config.ProbeStations, "probe-stations", false, "Periodically probe station URLs and report availability in /stations")
+	flag.DurationVar(&config.ProbeInterval, "probe-interval", 5*time.Minute, "Interval between station availability sweeps")
+	flag.BoolVar(&config.Validate, "validate", false, "Check the config and catalog, print a summary and exit without serving")
+	flag.IntVar(&config.ValidateProbe, "validate-probe", 0, "With -validate, also probe the first N stations")
+	flag.DurationVar(&config.ProbeTimeout, "probe-timeout", 5*time.Second, "Timeout for a single station availability probe")
+	flag.IntVar(&config.ProbeConcurrency, "probe-concurrency", 8, "Stations probed in parallel during an availability sweep")
+	flag.DurationVar(&config.ProbeOriginJitter, "probe-origin-jitter", 500*time.Millisecond, "Max random delay between probes of the same origin")
+	flag.IntVar(&config.MaxConnsPerOrigin, "max-conns-per-origin", 0, "Maximum simultaneous stream connections to one origin host (0 = unlimited)")
+	flag.DurationVar(&config.OriginQueueTimeout, "origin-queue-timeout", 2*time.Second, "How long a listener waits for a saturated origin before getting a 503")
+	flag.DurationVar(&config.MetadataTimeout, "metadata-timeout", 10*time.Second, "How long to wait for the first ICY metadata block")
+	flag.BoolVar(&config.TrimNames, "trim-names", false, "Ignore leading/trailing whitespace in upstream station names when matching")
+	flag.BoolVar(&config.CollapseNames, "collapse-names", false, "Treat runs of whitespace in station names as a single space when matching")
+	flag.BoolVar(&config.RejectHTML, "reject-html", true, "Answer 502 instead of streaming when an origin returns an HTML page")
+	flag.DurationVar(&config.ConnectJitter, "connect-jitter", 0, "Maximum random delay before connecting to an origin, to spread reconnection storms (0 = disabled)")
+	flag.IntVar(&config.PlaylistMaxDepth, "playlist-max-depth", 2, "How many levels of .pls/.m3u station URLs to follow (0 = don't follow)")
+	flag.DurationVar(&config.CatalogProbeInterval, "catalog-probe-interval", 0, "Interval for timing a background station list fetch (0 = disabled)")
+	flag.DurationVar(&config.StreamStartTimeout, "stream-start-timeout", 8*time.Second, "How long to wait for the first audio byte from an origin (0 = forever)")
+	flag.DurationVar(&config.StreamIdleTimeout, "stream-idle-timeout", 30*time.Second, "How long an origin may go silent mid-stream before it is dropped (0 = forever)")
+	flag.IntVar(&config.QRSize, "qr-size", 256, "Width and height in pixels of station QR codes")
+	flag.StringVar(&config.QRLevel, "qr-level", "medium", "QR code error correction level (low, medium, high, highest)")
+	flag.StringVar(&config.StreamConnection, "stream-connection", "", "Force the Connection header on stream responses (close or keep-alive; HTTP/1.x only)")
+	flag.DurationVar(&config.CORSMaxAge, "cors-max-age", 10*time.Minute, "How long browsers may cache CORS preflight results")
+	flag.BoolVar(&config.CORSAllowCredentials, "cors-credentials", false, "Allow credentialed cross-origin requests")
+	flag.BoolVar(&config.AdminCORS, "admin-cors", false, "Allow cross-origin requests to /admin routes")
+	var corsOrigins, corsMethods, corsHeaders string
+	flag.StringVar(&corsOrigins, "cors-origins", "*", "Comma-separated origins allowed cross-origin access (* = any)")
+	flag.StringVar(&corsMethods, "cors-methods", "GET,HEAD,POST,OPTIONS", "Comma-separated methods allowed on cross-origin requests to public routes")
+	flag.StringVar(&corsHeaders, "cors-headers", "Content-Type,Authorization,X-API-Key,Range,Icy-MetaData", "Comma-separated request headers allowed on cross-origin requests to public routes")
+	flag.StringVar(&userAgents, "upstream-user-agents", "ICY/5.0", "Comma-separated User-Agents to rotate through for stream connections")
+	var forwardHeaders string
+	flag.StringVar(&forwardHeaders, "forward-headers", "", "Comma-separated client request headers to pass on to stream upstreams (e.g. Referer)")
+	flag.StringVar(&config.UserAgentRotation, "user-agent-rotation", "roundrobin", "How to rotate upstream User-Agents (roundrobin or random)")
+	flag.BoolVar(&config.AudioLevels, "audio-levels", false, "Decode active streams with ffmpeg to publish audio level metrics (CPU intensive)")
+	flag.StringVar(&config.FFmpegPath, "ffmpeg", "ffmpeg", "Path to the ffmpeg binary")
+	flag.BoolVar(&config.CaseInsensitiveRoutes, "case-insensitive-routes", false, "Redirect requests like /Stream/foo to /stream/foo")
+	flag.StringVar(&schema, "schema", "", "Catalog field mapping, e.g. id=station_id,name=title,url=stream")
+	flag.StringVar(&fallbackSchema, "fallback-schema", "", "Field mapping to try when the primary schema doesn't match")
+	flag.BoolVar(&config.EnableExpvar, "expvar", false, "Expose key metrics at /debug/vars")
+	flag.IntVar(&config.CooldownFailures, "cooldown-failures", 0, "Stream failures that put a station into cooldown (0 = disabled)")
+	flag.DurationVar(&config.CooldownWindow, "cooldown-window", time.Minute, "Window in which cooldown failures are counted")
+	flag.DurationVar(&config.CooldownDuration, "cooldown-duration", 2*time.Minute, "How long a failing station is refused")
+	flag.DurationVar(&config.SlowTTFBThreshold, "slow-ttfb", 3*time.Second, "Log streams whose first byte takes longer than this (0 = off)")
+	flag.DurationVar(&config.SlowCatalogThreshold, "slow-catalog", 2*time.Second, "Log station list fetches slower than this (0 = off)")
+	flag.StringVar(&config.VirtualStation, "virtual-station", "", "Name of a built-in test station, e.g. _silence (empty = disabled)")
+	flag.StringVar(&config.VirtualFormat, "virtual-format", "mp3", "Virtual station format: mp3 (silence) or wav (silence or tone)")
+	flag.IntVar(&config.VirtualBitrate, "virtual-bitrate", 128, "Virtual station MP3 bitrate in kbps")
+	flag.IntVar(&config.VirtualToneHz, "virtual-tone", 0, "Virtual station test tone frequency in Hz, wav only (0 = silence)")
+	var metricsAllow string
+	flag.IntVar(&config.MaxActiveStreams, "max-active-streams", 0, "Max simultaneous streams server-wide (0 = unlimited)")
+	flag.IntVar(&config.MaxStreamsPerIP, "max-streams-per-ip", 0, "Max concurrent streams per client IP (0 = unlimited)")
+	flag.IntVar(&config.StreamRatePerIP, "stream-rate-per-ip", 0, "Max stream requests per minute per client IP (0 = unlimited)")
+	flag.BoolVar(&config.EnablePprof, "pprof", false, "Expose /debug/pprof profiling endpoints")
+	flag.StringVar(&config.PprofAddr, "pprof-addr", "", "Serve pprof on this address (e.g. 127.0.0.1:6060) instead of the public port")
+	var authExempt string
+	flag.StringVar(&config.BasicUser, "basic-user", "", "Require HTTP Basic auth with this user name")
+	flag.StringVar(&authExempt, "auth-exempt", "/health,/ready,/metrics,/favicon.ico", "Comma-separated paths that never require credentials")
+	var streamAllow, streamDeny string
+	flag.StringVar(&streamAllow, "stream-allow-cidrs", "", "Comma-separated networks station streams, logos and webhooks may reach even if private or loopback")
+	flag.StringVar(&streamDeny, "stream-deny-cidrs", "", "Comma-separated extra networks station streams, logos and webhooks may never reach")
+	var trustedProxies string
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "Comma-separated proxy networks whose X-Forwarded-For is trusted for the client IP")
+	flag.StringVar(&metricsAllow, "metrics-allow-cidrs", "", "Comma-separated networks allowed to scrape /metrics (empty = any)")
+	flag.IntVar(&config.MetricsRateLimit, "metrics-rate-limit", 0, "Max /metrics scrapes per minute per client (0 = unlimited)")
+	flag.StringVar(&config.CatalogMerge, "catalog-merge", "first", "Which source wins when catalogs share a station name or ID: first or last")
+	flag.StringVar(&config.CatalogWebhook, "catalog-webhook", "", "URL to POST catalog change events to")
+	flag.IntVar(&config.UpstreamReadBuffer, "upstream-read-buffer", 32*1024, "Upstream read buffer size in bytes")
+	flag.DurationVar(&config.FlushInterval, "flush-interval", 250*time.Millisecond, "Flush buffered stream data to the client at least this often (0 = only when the buffer fills)")
+	flag.IntVar(&config.StreamBufferSize, "stream-buffer-size", defaultStreamBufferSize, "Client write and copy buffer size in bytes")
+	flag.IntVar(&config.LogoMaxBytes, "logo-max-bytes", 16*1024, "Largest logo to inline with ?embed_logos=1")
+	flag.BoolVar(&config.LearnRedirects, "learn-redirects", false, "Use a station's 301 redirect target as its URL override")
+	flag.IntVar(&config.MaxLearnedRedirects, "max-learned-redirects", 3, "Max redirect-learned URL changes per station")
+	flag.IntVar(&config.MaxBodyBytes, "max-body-bytes", 64*1024, "Largest request body accepted by write endpoints")
+	flag.BoolVar(&config.HLS, "hls", false, "Serve stations as live HLS at /hls/:station/playlist.m3u8 (requires ffmpeg)")
+	flag.DurationVar(&config.HLSSegmentDuration, "hls-segment", 6*time.Second, "HLS segment duration")
+	flag.IntVar(&config.HLSWindow, "hls-window", 6, "Segments kept in the live HLS playlist")
+	flag.DurationVar(&config.HLSIdleTimeout, "hls-idle-timeout", time.Minute, "Stop an HLS session after this long without requests")
+	flag.StringVar(&config.LogFormat, "log-format", "json", "Log format: json, or text for local development")
+	flag.BoolVar(&config.AccessLog, "access-log", true, "Log every HTTP request")
+	flag.TextVar(&config.LogLevel, "log-level", slog.LevelInfo, "Minimum log level: debug, info, warn or error")
+	flag.BoolVar(&config.Relay, "relay", false, "Fan out one upstream connection per station to all of its listeners")
+	flag.DurationVar(&config.RelayLinger, "relay-linger", 5*time.Second, "Keep a relayed upstream open this long after its last listener leaves")
+	flag.StringVar(&config.ScrobbleWebhook, "scrobble-webhook", "", "URL to POST {station, title, timestamp} to when a relayed station's title changes")
+	flag.DurationVar(&config.ScrobbleDebounce, "scrobble-debounce", 5*time.Second, "How long a new title must hold before it is reported")
+	flag.IntVar(&config.HistorySize, "history-size", 0, "Recent titles kept per relayed station and served at /history/:station (0 = off)")
+	flag.DurationVar(&config.HistoryIdle, "history-idle", time.Hour, "Drop a station's title history after this long without listeners or /history requests")
+	flag.IntVar(&config.RelayBacklog, "relay-backlog", 64*1024, "Bytes of recent audio sent to listeners joining a relayed station")
+	flag.IntVar(&config.StreamRetries, "stream-retries", 2, "Retries for a failed stream connection or 502/503/504")
+	flag.DurationVar(&config.StreamRetryDelay, "stream-retry-delay", 500*time.Millisecond, "Delay before the first stream retry, doubled each time")
+	var stationBase string
+	flag.StringVar(&stationBase, "station-base-url", "", "Base URL for catalog stations whose URL is relative (e.g. /stream/128)")
+	var upstreamProxy, catalogProxy string
+	flag.StringVar(&upstreamProxy, "upstream-proxy", "", "Proxy URL for stream connections (http://, https:// or socks5://)")
+	flag.StringVar(&catalogProxy, "catalog-proxy", "", "Proxy URL for catalog fetches, or \"upstream\" to reuse -upstream-proxy")
+	flag.DurationVar(&config.ConnectTimeout, "connect-timeout", 5*time.Second, "Timeout for connecting to upstreams")
+	flag.DurationVar(&config.ResponseHeaderTimeout, "response-header-timeout", 10*time.Second, "Timeout for upstream response headers")
+	flag.DurationVar(&config.APITimeout, "api-timeout", 15*time.Second, "Overall timeout for a station list fetch")
+	flag.IntVar(&config.MaxIdleConns, "max-idle-conns", 100, "Idle upstream connections kept for reuse")
+	flag.DurationVar(&config.IdleConnTimeout, "idle-conn-timeout", 90*time.Second, "How long an idle upstream connection is kept")
+	flag.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Grace period for active streams on shutdown")
+	flag.DurationVar(&config.CacheTTL, "cache-ttl", 30*time.Second, "How long to cache the station list (0 = no caching)")
+	flag.BoolVar(&config.SuggestStations, "suggest-stations", false, "Suggest similar station names when a station isn't found")
+	flag.BoolVar(&config.Selftest, "selftest", false, "Enable the /admin/selftest load test endpoint")
+	flag.StringVar(&config.DuplicateStreams, "duplicate-streams", "", "Handling of a client's second stream of a station: reject or takeover (empty = allow)")
+
+	var configFile string
+	flag.StringVar(&configFile, "config", "", "JSON config file keyed by flag name; flags override the environment, which overrides the file")
+
+	flag.Parse()
+	explicit := explicitFlags()
+	if configFile == "" {
+		configFile = os.Getenv("RADIO_CONFIG")
+	}
+	if configFile != "" {
+		config.FileSettings = applyConfigFile(configFile, explicit)
+	}
+
+	// Environment variable overrides
+	if apiEnv := os.Getenv("RADIO_API_ENDPOINT"); apiEnv != "" {
+		config.APIEndpoint = apiEnv
+	}
+	if portEnv := os.Getenv("RADIO_PORT"); portEnv != "" {
+		config.Port = portEnv
+	}
+	if certEnv := os.Getenv("RADIO_SSL_CERT"); certEnv != "" {
+		config.SSLCert = certEnv
+	}
+	if keyEnv := os.Getenv("RADIO_SSL_KEY"); keyEnv != "" {
+		config.SSLKey = keyEnv
+	}
+	if sourcesEnv := os.Getenv("RADIO_SOURCES"); sourcesEnv != "" {
+		config.SourcesFile = sourcesEnv
+	}
+	envDuration("RADIO_OVERRIDE_TTL", &config.OverrideTTL)
+	envInt("RADIO_MAX_API_CONNS", &config.MaxAPIConns)
+	envDuration("RADIO_API_QUEUE_TIMEOUT", &config.APIQueueTimeout)
+	envBool("RADIO_PROBE_STATIONS", &config.ProbeStations)
+	envDuration("RADIO_PROBE_INTERVAL", &config.ProbeInterval)
+	envDuration("RADIO_PROBE_TIMEOUT", &config.ProbeTimeout)
+	envInt("RADIO_HEALTH_CONCURRENCY", &config.ProbeConcurrency)
+	envDuration("RADIO_PROBE_ORIGIN_JITTER", &config.ProbeOriginJitter)
+	envInt("RADIO_MAX_CONNS_PER_ORIGIN", &config.MaxConnsPerOrigin)
+	envDuration("RADIO_ORIGIN_QUEUE_TIMEOUT", &config.OriginQueueTimeout)
+	envDuration("RADIO_METADATA_TIMEOUT", &config.MetadataTimeout)
+	envBool("RADIO_TRIM_NAMES", &config.TrimNames)
+	envBool("RADIO_COLLAPSE_NAMES", &config.CollapseNames)
+	envBool("RADIO_REJECT_HTML", &config.RejectHTML)
+	envDuration("RADIO_CONNECT_JITTER", &config.ConnectJitter)
+	envInt("RADIO_PLAYLIST_MAX_DEPTH", &config.PlaylistMaxDepth)
+	envDuration("RADIO_CATALOG_PROBE_INTERVAL", &config.CatalogProbeInterval)
+	envDuration("RADIO_STREAM_START_TIMEOUT", &config.StreamStartTimeout)
+	envDuration("RADIO_STREAM_IDLE_TIMEOUT", &config.StreamIdleTimeout)
+	envDuration("RADIO_STREAM_STALL_TIMEOUT", &config.StreamIdleTimeout)
+	envInt("RADIO_QR_SIZE", &config.QRSize)
+	if qrLevelEnv := os.Getenv("RADIO_QR_LEVEL"); qrLevelEnv != "" {
+		config.QRLevel = qrLevelEnv
+	}
+	if connEnv := os.Getenv("RADIO_STREAM_CONNECTION"); connEnv != "" {
+		config.StreamConnection = connEnv
+	}
+	envDuration("RADIO_CORS_MAX_AGE", &config.CORSMaxAge)
+	envBool("RADIO_CORS_CREDENTIALS", &config.CORSAllowCredentials)
+	envBool("RADIO_ADMIN_CORS", &config.AdminCORS)
+	if originsEnv := os.Getenv("RADIO_CORS_ORIGINS"); originsEnv != "" {
+		corsOrigins = originsEnv
+	}
+	if methodsEnv := os.Getenv("RADIO_CORS_METHODS"); methodsEnv != "" {
+		corsMethods = methodsEnv
+	}
+	if headersEnv := os.Getenv("RADIO_CORS_HEADERS"); headersEnv != "" {
+		corsHeaders = headersEnv
+	}
+	if uaEnv := os.Getenv("RADIO_UPSTREAM_USER_AGENT"); uaEnv != "" {
+		userAgents = uaEnv
+	}
+	if uaEnv := os.Getenv("RADIO_UPSTREAM_USER_AGENTS"); uaEnv != "" {
+		userAgents = uaEnv
+	}
+	if forwardEnv := os.Getenv("RADIO_FORWARD_HEADERS"); forwardEnv != "" {
+		forwardHeaders = forwardEnv
+	}
+	if rotationEnv := os.Getenv("RADIO_USER_AGENT_ROTATION"); rotationEnv != "" {
+		config.UserAgentRotation = rotationEnv
+	}
+	config.UpstreamUserAgents = splitList(userAgents)
+	for _, name := range splitList(forwardHeaders) {
+		config.ForwardHeaders = append(config.ForwardHeaders, http.CanonicalHeaderKey(name))
+	}
+	envBool("RADIO_AUDIO_LEVELS", &config.AudioLevels)
+	if ffmpegEnv := os.Getenv("RADIO_FFMPEG_PATH"); ffmpegEnv != "" {
+		config.FFmpegPath = ffmpegEnv
+	}
+	envBool("RADIO_CASE_INSENSITIVE_ROUTES", &config.CaseInsensitiveRoutes)
+	if schemaEnv := os.Getenv("RADIO_SCHEMA"); schemaEnv != "" {
+		schema = schemaEnv
+	}
+	if fallbackEnv := os.Getenv("RADIO_FALLBACK_SCHEMA"); fallbackEnv != "" {
+		fallbackSchema = fallbackEnv
+	}
+	envBool("RADIO_ENABLE_EXPVAR", &config.EnableExpvar)
+	envInt("RADIO_COOLDOWN_FAILURES", &config.CooldownFailures)
+	envDuration("RADIO_COOLDOWN_WINDOW", &config.CooldownWindow)
+	envDuration("RADIO_COOLDOWN_DURATION", &config.CooldownDuration)
+	envDuration("RADIO_SLOW_TTFB", &config.SlowTTFBThreshold)
+	envDuration("RADIO_SLOW_CATALOG", &config.SlowCatalogThreshold)
+	if virtualEnv := os.Getenv("RADIO_VIRTUAL_STATION"); virtualEnv != "" {
+		config.VirtualStation = virtualEnv
+	}
+	if formatEnv := os.Getenv("RADIO_VIRTUAL_FORMAT"); formatEnv != "" {
+		config.VirtualFormat = formatEnv
+	}
+	envInt("RADIO_VIRTUAL_BITRATE", &config.VirtualBitrate)
+	envInt("RADIO_VIRTUAL_TONE", &config.VirtualToneHz)
+	if allowEnv := os.Getenv("RADIO_METRICS_ALLOW_CIDRS"); allowEnv != "" {
+		metricsAllow = allowEnv
+	}
+	envInt("RADIO_METRICS_RATE_LIMIT", &config.MetricsRateLimit)
+	envInt("RADIO_MAX_ACTIVE_STREAMS", &config.MaxActiveStreams)
+	envInt("RADIO_MAX_STREAMS_PER_IP", &config.MaxStreamsPerIP)
+	envInt("RADIO_STREAM_RATE_PER_IP", &config.StreamRatePerIP)
+	envBool("RADIO_ENABLE_PPROF", &config.EnablePprof)
+	if pprofEnv := os.Getenv("RADIO_PPROF_ADDR"); pprofEnv != "" {
+		config.PprofAddr = pprofEnv
+	}
+	// Secrets are only read from the environment so they stay out of ps output
+	config.APIKey = os.Getenv("RADIO_API_KEY")
+	config.BasicPassword = os.Getenv("RADIO_BASIC_PASSWORD")
+	if userEnv := os.Getenv("RADIO_BASIC_USER"); userEnv != "" {
+		config.BasicUser = userEnv
+	}
+	if exemptEnv := os.Getenv("RADIO_AUTH_EXEMPT"); exemptEnv != "" {
+		authExempt = exemptEnv
+	}
+	if allowEnv := os.Getenv("RADIO_STREAM_ALLOW_CIDRS"); allowEnv != "" {
+		streamAllow = allowEnv
+	}
+	if denyEnv := os.Getenv("RADIO_STREAM_DENY_CIDRS"); denyEnv != "" {
+		streamDeny = denyEnv
+	}
+	if proxiesEnv := os.Getenv("RADIO_TRUSTED_PROXIES"); proxiesEnv != "" {
+		trustedProxies = proxiesEnv
+	}
+	if webhookEnv := os.Getenv("RADIO_CATALOG_WEBHOOK"); webhookEnv != "" {
+		config.CatalogWebhook = webhookEnv
+	}
+	if mergeEnv := os.Getenv("RADIO_CATALOG_MERGE"); mergeEnv != "" {
+		config.CatalogMerge = mergeEnv
+	}
+	envInt("RADIO_UPSTREAM_READ_BUFFER", &config.UpstreamReadBuffer)
+	envInt("RADIO_STREAM_BUFFER_SIZE", &config.StreamBufferSize)
+	envDuration("RADIO_FLUSH_INTERVAL", &config.FlushInterval)
+	envInt("RADIO_LOGO_MAX_BYTES", &config.LogoMaxBytes)
+	envBool("RADIO_LEARN_REDIRECTS", &config.LearnRedirects)
+	envInt("RADIO_MAX_LEARNED_REDIRECTS", &config.MaxLearnedRedirects)
+	envInt("RADIO_MAX_BODY_BYTES", &config.MaxBodyBytes)
+	envBool("RADIO_SELFTEST", &config.Selftest)
+	envBool("RADIO_SUGGEST_STATIONS", &config.SuggestStations)
+	envDuration("RADIO_CACHE_TTL", &config.CacheTTL)
+	envDuration("RADIO_SHUTDOWN_TIMEOUT", &config.ShutdownTimeout)
+	envDuration("RADIO_CONNECT_TIMEOUT", &config.ConnectTimeout)
+	if baseEnv := os.Getenv("RADIO_STATION_BASE_URL"); baseEnv != "" {
+		stationBase = baseEnv
+	}
+	if proxyEnv := os.Getenv("RADIO_UPSTREAM_PROXY"); proxyEnv != "" {
+		upstreamProxy = proxyEnv
+	}
+	if proxyEnv := os.Getenv("RADIO_CATALOG_PROXY"); proxyEnv != "" {
+		catalogProxy = proxyEnv
+	}
+	envInt("RADIO_STREAM_RETRIES", &config.StreamRetries)
+	envBool("RADIO_HLS", &config.HLS)
+	envDuration("RADIO_HLS_SEGMENT", &config.HLSSegmentDuration)
+	envInt("RADIO_HLS_WINDOW", &config.HLSWindow)
+	envDuration("RADIO_HLS_IDLE_TIMEOUT", &config.HLSIdleTimeout)
+	envBool("RADIO_RELAY", &config.Relay)
+	envBool("RADIO_GIN_ACCESS_LOG", &config.AccessLog)
+	if formatEnv := os.Getenv("RADIO_LOG_FORMAT"); formatEnv != "" {
+		config.LogFormat = formatEnv
+	}
+	if levelEnv := os.Getenv("RADIO_LOG_LEVEL"); levelEnv != "" {
+		if err := config.LogLevel.UnmarshalText([]byte(levelEnv)); err != nil {
+			log.Fatalf("Error: invalid RADIO_LOG_LEVEL %q", levelEnv)
+		}
+	}
+	envDuration("RADIO_RELAY_LINGER", &config.RelayLinger)
+	envInt("RADIO_RELAY_BACKLOG", &config.RelayBacklog)
+	if scrobbleEnv := os.Getenv("RADIO_SCROBBLE_WEBHOOK"); scrobbleEnv != "" {
+		config.ScrobbleWebhook = scrobbleEnv
+	}
+	envDuration("RADIO_SCROBBLE_DEBOUNCE", &config.ScrobbleDebounce)
+	envInt("RADIO_HISTORY_SIZE", &config.HistorySize)
+	envDuration("RADIO_HISTORY_IDLE", &config.HistoryIdle)
+	envDuration("RADIO_STREAM_RETRY_DELAY", &config.StreamRetryDelay)
+	envDuration("RADIO_RESPONSE_HEADER_TIMEOUT", &config.ResponseHeaderTimeout)
+	envDuration("RADIO_API_TIMEOUT", &config.APITimeout)
+	envInt("RADIO_MAX_IDLE_CONNS", &config.MaxIdleConns)
+	envDuration("RADIO_IDLE_CONN_TIMEOUT", &config.IdleConnTimeout)
+	if duplicateEnv := os.Getenv("RADIO_DUPLICATE_STREAMS"); duplicateEnv != "" {
+		config.DuplicateStreams = duplicateEnv
+	}
+	reapplyFlags(explicit)
+	config.ConfigFile = configFile
+	config.ExplicitFlags = explicit
+
+	if config.OverrideTTL <= 0 {
+		log.Fatal("Error: override TTL must be positive")
+	}
+	if config.MaxAPIConns < 0 {
+		log.Fatal("Error: max API connections cannot be negative")
+	}
+	if config.ProbeStations && (config.ProbeInterval <= 0 || config.ProbeTimeout <= 0) {
+		log.Fatal("Error: probe interval and timeout must be positive")
+	}
+	if config.ProbeStations && (config.ProbeConcurrency < 1 || config.ProbeConcurrency > 256) {
+		log.Fatal("Error: probe concurrency must be between 1 and 256")
+	}
+	if config.ProbeOriginJitter < 0 {
+		log.Fatal("Error: probe origin jitter cannot be negative")
+	}
+	if config.MaxConnsPerOrigin < 0 {
+		log.Fatal("Error: max connections per origin cannot be negative")
+	}
+	if config.MetadataTimeout <= 0 {
+		log.Fatal("Error: metadata timeout must be positive")
+	}
+	if config.ConnectJitter < 0 {
+		log.Fatal("Error: connect jitter cannot be negative")
+	}
+	if config.PlaylistMaxDepth < 0 {
+		log.Fatal("Error: playlist max depth cannot be negative")
+	}
+	if config.CatalogProbeInterval < 0 {
+		log.Fatal("Error: catalog probe interval cannot be negative")
+	}
+	if config.StreamStartTimeout < 0 || config.StreamIdleTimeout < 0 {
+		log.Fatal("Error: stream timeouts cannot be negative")
+	}
+	if config.QRSize < 21 || config.QRSize > 4096 {
+		log.Fatal("Error: QR size must be between 21 and 4096 pixels")
+	}
+	if _, ok := qrLevels[config.QRLevel]; !ok {
+		log.Fatal("Error: QR level must be one of low, medium, high, highest")
+	}
+	switch config.StreamConnection {
+	case "", "close", "keep-alive":
+	default:
+		log.Fatal("Error: stream connection must be close or keep-alive")
+	}
+	if len(config.UpstreamUserAgents) == 0 {
+		log.Fatal("Error: at least one upstream User-Agent is required")
+	}
+	for _, name := range config.ForwardHeaders {
+		if unforwardableHeaders[name] {
+			log.Fatalf("Error: header %s cannot be forwarded", name)
+		}
+	}
+	if config.UserAgentRotation != "roundrobin" && config.UserAgentRotation != "random" {
+		log.Fatal("Error: user agent rotation must be roundrobin or random")
+	}
+	if config.CooldownFailures < 0 {
+		log.Fatal("Error: cooldown failures cannot be negative")
+	}
+	if config.CooldownFailures > 0 && (config.CooldownWindow <= 0 || config.CooldownDuration <= 0) {
+		log.Fatal("Error: cooldown window and duration must be positive")
+	}
+	if config.SlowTTFBThreshold < 0 || config.SlowCatalogThreshold < 0 {
+		log.Fatal("Error: slow response thresholds cannot be negative")
+	}
+	if config.VirtualStation != "" {
+		switch config.VirtualFormat {
+		case "mp3":
+			if _, ok := mp3BitrateIndex[config.VirtualBitrate]; !ok {
+				log.Fatal("Error: virtual bitrate must be a standard MP3 bitrate (32-320 kbps)")
+			}
+			if config.VirtualToneHz != 0 {
+				log.Fatal("Error: a virtual test tone requires wav format")
+			}
+		case "wav":
+			if config.VirtualToneHz < 0 || config.VirtualToneHz >= wavSampleRate/2 {
+				log.Fatal("Error: virtual tone must be between 0 and 11024 Hz")
+			}
+		default:
+			log.Fatal("Error: virtual format must be mp3 or wav")
+		}
+	}
+	if config.UpstreamReadBuffer < 1024 || config.UpstreamReadBuffer > 1024*1024 {
+		log.Fatal("Error: upstream read buffer must be between 1KB and 1MB")
+	}
+	// Naming a pprof listener is opt-in enough on its own
+	if config.PprofAddr != "" {
+		config.EnablePprof = true
+	}
+	if config.FlushInterval < 0 {
+		log.Fatal("Error: flush interval cannot be negative")
+	}
+	if config.StreamBufferSize < 1024 || config.StreamBufferSize > 4*1024*1024 {
+		log.Printf("Warning: stream buffer size %d is outside 1KB-4MB, using %d", config.StreamBufferSize, defaultStreamBufferSize)
+		config.StreamBufferSize = defaultStreamBufferSize
+	}
+	if config.LogoMaxBytes <= 0 {
+		log.Fatal("Error: logo max bytes must be positive")
+	}
+	if config.LearnRedirects && config.MaxLearnedRedirects <= 0 {
+		log.Fatal("Error: max learned redirects must be positive")
+	}
+	if config.MaxBodyBytes <= 0 {
+		log.Fatal("Error: max body bytes must be positive")
+	}
+	switch config.DuplicateStreams {
+	case "", "reject", "takeover":
+	default:
+		log.Fatal("Error: duplicate streams must be reject or takeover")
+	}
+	if config.HLS && (config.HLSSegmentDuration < time.Second || config.HLSWindow < 2 || config.HLSIdleTimeout <= 0) {
+		log.Fatal("Error: HLS needs a segment of at least 1s, a window of at least 2 and a positive idle timeout")
+	}
+	if config.LogFormat != "json" && config.LogFormat != "text" {
+		log.Fatal("Error: log format must be json or text")
+	}
+	if config.Relay && (config.RelayLinger < 0 || config.RelayBacklog < 0 || config.RelayBacklog > 16<<20) {
+		log.Fatal("Error: relay linger must not be negative and relay backlog must be between 0 and 16MB")
+	}
+	if config.CatalogMerge != "first" && config.CatalogMerge != "last" {
+		log.Fatal("Error: catalog merge must be first or last")
+	}
+	if config.ScrobbleWebhook != "" && !config.Relay {
+		log.Fatal("Error: the scrobble webhook reads titles from relayed feeds and needs -relay")
+	}
+	if config.HistorySize != 0 && !config.Relay {
+		log.Fatal("Error: title history reads titles from relayed feeds and needs -relay")
+	}
+	if config.HistorySize < 0 || config.HistorySize > 1000 {
+		log.Fatal("Error: history size must be between 0 and 1000")
+	}
+	if config.HistorySize > 0 && config.HistoryIdle <= 0 {
+		log.Fatal("Error: history idle timeout must be positive")
+	}
+	if config.ScrobbleDebounce < 0 {
+		log.Fatal("Error: scrobble debounce cannot be negative")
+	}
+	if config.StreamRetries < 0 || config.StreamRetries > 10 {
+		log.Fatal("Error: stream retries must be between 0 and 10")
+	}
+	if config.StreamRetries > 0 && config.StreamRetryDelay <= 0 {
+		log.Fatal("Error: stream retry delay must be positive")
+	}
+	if config.ConnectTimeout <= 0 || config.ResponseHeaderTimeout <= 0 || config.APITimeout <= 0 {
+		log.Fatal("Error: upstream timeouts must be positive")
+	}
+	if config.MaxIdleConns < 0 || config.IdleConnTimeout < 0 {
+		log.Fatal("Error: idle connection settings cannot be negative")
+	}
+	streamAllowNets, err := parseCIDRs(splitList(streamAllow))
+	if err != nil {
+		log.Fatalf("Error: invalid stream allowlist: %v", err)
+	}
+	streamDenyNets, err := parseCIDRs(splitList(streamDeny))
+	if err != nil {
+		log.Fatalf("Error: invalid stream denylist: %v", err)
+	}
+	config.StreamAddresses = newAddressPolicy(streamAllowNets, streamDenyNets)
+	if stationBase != "" {
+		if config.StationBaseURL, err = validateOutboundURL(stationBase); err != nil {
+			log.Fatalf("Error: invalid station base URL: %v", err)
+		}
+	}
+	if upstreamProxy != "" {
+		if config.UpstreamProxy, err = parseProxyURL(upstreamProxy); err != nil {
+			log.Fatalf("Error: invalid upstream proxy: %v", err)
+		}
+	}
+	switch catalogProxy {
+	case "":
+	case "upstream":
+		if config.UpstreamProxy == nil {
+			log.Fatal("Error: catalog proxy \"upstream\" needs an upstream proxy")
+		}
+		config.CatalogProxy = config.UpstreamProxy
+	default:
+		if config.CatalogProxy, err = parseProxyURL(catalogProxy); err != nil {
+			log.Fatalf("Error: invalid catalog proxy: %v", err)
+		}
+	}
+	config.StreamClient, config.APIClient = newUpstreamClients(config)
+	if config.ShutdownTimeout < 0 {
+		log.Fatal("Error: shutdown timeout cannot be negative")
+	}
+	if config.CacheTTL < 0 {
+		log.Fatal("Error: cache TTL cannot be negative")
+	}
+	if config.MetricsRateLimit < 0 {
+		log.Fatal("Error: metrics rate limit cannot be negative")
+	}
+	metricsNets, err := parseCIDRs(splitList(metricsAllow))
+	if err != nil {
+		log.Fatalf("Error: invalid metrics allowlist: %v", err)
+	}
+	config.MetricsAllowCIDRs = metricsNets
+	if config.MaxStreamsPerIP < 0 || config.StreamRatePerIP < 0 {
+		log.Fatal("Error: per-client stream limits cannot be negative")
+	}
+	if config.MaxActiveStreams < 0 {
+		log.Fatal("Error: max active streams cannot be negative")
+	}
+	config.CORSOrigins = splitList(corsOrigins)
+	config.CORSMethods = splitList(strings.ToUpper(corsMethods))
+	config.CORSHeaders = splitList(corsHeaders)
+	if len(config.CORSOrigins) == 0 || len(config.CORSMethods) == 0 {
+		log.Fatal("Error: CORS origins and methods cannot be empty")
+	}
+	config.TrustedProxies = splitList(trustedProxies)
+	config.AuthExempt = splitList(authExempt)
+	if config.BasicUser != "" && config.BasicPassword == "" {
+		log.Fatal("Error: Basic auth needs RADIO_BASIC_PASSWORD")
+	}
+	if _, err := parseCIDRs(config.TrustedProxies); err != nil {
+		log.Fatalf("Error: invalid trusted proxies: %v", err)
+	}
+	primary, err := parseFieldMap(schema)
+	if err != nil {
+		log.Fatalf("Error: invalid schema: %v", err)
+	}
+	config.Schemas = []fieldMap{primary}
+	if fallbackSchema != "" {
+		fallback, err := parseFieldMap(fallbackSchema)
+		if err != nil {
+			log.Fatalf("Error: invalid fallback schema: %v", err)
+		}
+		config.Schemas = append(config.Schemas, fallback)
+	}
+
+	if config.SourcesFile != "" {
+		sources, err := loadStationSources(config.SourcesFile)
+		if err != nil {
+			log.Fatalf("Error: invalid station sources file: %v", err)
+		}
+		config.Sources = sources
+	}
+
+	if config.APIEndpoint == "" && len(config.Sources) == 0 {
+		log.Fatal("Error: API endpoint must be provided")
+	}
+
+	config.EnableHTTPS = config.SSLCert != "" && config.SSLKey != ""
+
+	return config
 }
 
 func main() {
-    config := parseConfig()
-    
-    // Set Gin to release mode in production
-    gin.SetMode(gin.ReleaseMode)
-    
-    r := gin.Default()
-    r.Use(corsMiddleware())
-    
-    // Create a new logger instance
-    logger := log.New(log.Writer(), "[Radio-API] ", log.LstdFlags)
-    
-    // Routes
-    r.GET("/stations", getStationsHandler(config, logger))
-    r.GET("/stream/:station", streamStationHandler(config, logger))
-    r.GET("/health", healthCheckHandler())
-    
-    // Prometheus metrics endpoint
-    r.GET("/metrics", gin.WrapH(promhttp.Handler()))
-    
-    serverAddr := fmt.Sprintf(":%s", config.Port)
-    
-    if config.EnableHTTPS {
-        logger.Printf("Starting HTTPS server on port %s...", config.Port)
-        logger.Fatal(r.RunTLS(serverAddr, config.SSLCert, config.SSLKey))
-    } else {
-        logger.Printf("Starting HTTP server on port %s...", config.Port)
-        logger.Fatal(r.Run(serverAddr))
-    }
+	config := parseConfig()
+
+	logger := newLoggers(config)
+	if config.Validate {
+		os.Exit(runValidate(config, logger))
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(requestIDMiddleware())
+	if config.AccessLog {
+		r.Use(accessLogMiddleware())
+	}
+	r.Use(gin.RecoveryWithWriter(logger.Writer()))
+	// /stations/ redirects to /stations. Fixed-path redirects only fold the
+	// case of route segments; :station values are passed through untouched.
+	r.RedirectTrailingSlash = true
+	r.RedirectFixedPath = config.CaseInsensitiveRoutes
+	// Without trusted proxies the client IP is the connection's address
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Error: invalid trusted proxies: %v", err)
+	}
+	r.Use(limitRequestBody(int64(config.MaxBodyBytes)))
+	r.Use(gzipJSON())
+	live := newLiveConfig(config)
+	r.Use(corsMiddleware(live))
+	r.Use(authMiddleware(config))
+
+	api := newStationsAPI(config, logger)
+	overrides := newOverrideStore(config.OverrideTTL)
+	origins := newOriginLimiter(config)
+	cooldowns := newCooldownTracker(config)
+	redirects := newRedirectLearner(config, logger, overrides)
+	go watchReload(live, logger, api)
+	var virtual *virtualStation
+	if config.VirtualStation != "" {
+		virtual = newVirtualStation(config)
+	}
+	userAgents := newUserAgentPool(config)
+
+	var levels *levelMeter
+	if config.AudioLevels {
+		levels = newLevelMeter(config, logger)
+	}
+
+	var relay *relayHub
+	var titles *titleTracker
+	if config.Relay {
+		if config.ScrobbleWebhook != "" || config.HistorySize > 0 {
+			titles = newTitleTracker(config, logger)
+		}
+		relay = newRelayHub(config, logger, origins, userAgents, cooldowns, titles, levels)
+	}
+
+	// Load the catalog up front so /ready can turn ready without traffic
+	go api.fetch(context.Background())
+
+	if config.CatalogProbeInterval > 0 {
+		go api.runProbe(context.Background(), config.CatalogProbeInterval)
+	}
+
+	// Availability probing is opt-in since it adds load on every origin
+	var prober *availabilityProber
+	if config.ProbeStations {
+		prober = newAvailabilityProber(config, logger, api, overrides, cooldowns)
+		go prober.run(context.Background())
+	}
+
+	r.GET("/", indexHandler(api, logger))
+	r.GET("/favicon.ico", faviconHandler)
+	r.GET("/stations", getStationsHandler(api, logger, prober, newLogoCache(config, logger)))
+	r.GET("/stations/health", stationHealthHandler(api, logger, prober))
+	r.GET("/stations/search", searchStationsHandler(api, logger))
+	r.GET("/stations/:id", getStationByIDHandler(api, logger))
+	r.POST("/stations/resolve", resolveStationsHandler(api, logger, prober))
+	sessions := newStreamSessions(config)
+	stream := streamStationHandler(config, logger, api, overrides, origins, userAgents, levels, cooldowns, virtual, redirects, sessions, relay)
+	capacity, clients := newStreamCapacity(live), newStreamClients(live)
+	streamCap, streamLimit := streamCapacityLimit(capacity), streamClientLimit(clients)
+	r.GET("/stream/:station", streamCap, streamLimit, stream)
+	r.GET("/stream/id/:id", streamCap, streamLimit, stream)
+	if config.HLS {
+		hls, err := newHLSManager(config, logger, origins, userAgents)
+		if err != nil {
+			log.Fatalf("Error: failed to set up HLS: %v", err)
+		}
+		r.GET("/hls/:station/:file", hlsHandler(config, logger, api, overrides, hls, capacity, clients))
+	}
+	r.GET("/ws/:station", streamCap, streamLimit, wsStreamHandler(config, live, logger, api, overrides, origins, userAgents, cooldowns, sessions, relay))
+	r.GET("/nowplaying/:station", nowPlayingHandler(config, logger, api, overrides))
+	if config.HistorySize > 0 {
+		r.GET("/history/:station", historyHandler(logger, api, titles))
+	}
+	r.GET("/capabilities", capabilitiesHandler(config))
+	qr := qrCodeHandler(config, logger, api)
+	r.GET("/qr/:file", qr)
+	r.GET("/qr/id/:id", qr)
+	playlist := playlistHandler(logger, api)
+	r.GET("/playlist/:file", playlist)
+	r.GET("/playlist/id/:id", playlist)
+	r.GET("/metrics", metricsGuard(config), gin.WrapH(promhttp.Handler()))
+	if config.EnablePprof {
+		setupPprof(config, logger, r)
+	}
+	if config.EnableExpvar {
+		publishExpvars(api)
+		r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	}
+	r.GET("/ready", readyHandler(api))
+	r.GET("/stats", statsHandler())
+	r.GET("/health", func(c *gin.Context) {
+		if shuttingDown.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status": "healthy",
+			"time":   time.Now().Format(time.RFC3339),
+		})
+	})
+
+	if !authEnabled(config) {
+		logger.Printf("No credentials configured; /admin is only served to localhost")
+	}
+	admin := r.Group("/admin", adminGuard(config))
+	admin.GET("/override", listOverridesHandler(overrides))
+	admin.POST("/override", setOverrideHandler(overrides, logger))
+	admin.DELETE("/override", deleteOverrideHandler(overrides, logger))
+	admin.POST("/test-metadata", testMetadataHandler(config, logger))
+	admin.GET("/stations", adminStationsHandler(api, logger, cooldowns))
+	admin.GET("/uptime", uptimeHandler())
+	admin.GET("/relays", relaysHandler(relay))
+	admin.GET("/cache", cacheStatusHandler(api))
+	admin.POST("/cache/flush", cacheFlushHandler(api, logger))
+	if config.Selftest {
+		admin.POST("/selftest", selftestHandler(config, logger))
+	}
+
+	serverAddr := fmt.Sprintf(":%s", config.Port)
+	logger.Printf("Starting server on %s", serverAddr)
+
+	runServer(config, logger, &http.Server{Addr: serverAddr, Handler: r})
 }
 
-func corsMiddleware() gin.HandlerFunc {
-    return func(c *gin.Context) {
-        c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-        c.Writer.Header().Set("Access-Control-Allow-Methods", "GET")
-        c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-        
-        if c.Request.Method == "OPTIONS" {
-            c.AbortWithStatus(204)
-            return
-        }
-        
-        c.Next()
-    }
+func getStationsHandler(api *stationsAPI, logger *log.Logger, prober *availabilityProber, logos *logoCache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timer := prometheus.NewTimer(apiLatency.WithLabelValues("/stations"))
+		defer timer.ObserveDuration()
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+
+		var embedded map[string]string
+		if c.Query("embed_logos") == "1" {
+			urls := make([]string, 0, len(stations))
+			for _, station := range stations {
+				urls = append(urls, station.LogoURL)
+			}
+			embedded = logos.embed(urls)
+		}
+
+		// ?format=full adds IDs and absolute URLs built from the request
+		full := c.Query("format") == "full"
+		base := requestBaseURL(c)
+
+		var response []StationResponse
+		for _, station := range stations {
+			entry := StationResponse{Name: station.Name, Slug: station.Slug, Logo: embedded[station.LogoURL], Duplicate: station.Duplicate}
+			if station.Duplicate {
+				entry.ID = station.ID
+			}
+			if full {
+				segment := stationPathSegment(station)
+				entry.ID = station.ID
+				entry.StreamURL = base + "/stream/" + segment
+				entry.PlaylistURL = base + "/playlist/" + segment + ".m3u"
+			}
+			if prober != nil {
+				if result, ok := prober.lookup(station); ok {
+					entry.Available = &result.Available
+				}
+			}
+			response = append(response, entry)
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func streamStationHandler(config Config, logger *log.Logger, api *stationsAPI, overrides *overrideStore, origins *originLimiter, userAgents *userAgentPool, levels *levelMeter, cooldowns *cooldownTracker, virtual *virtualStation, redirects *redirectLearner, sessions *streamSessions, relay *relayHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stationName, extType := splitStreamExtension(c.Param("station"))
+		// /stream/id/:id looks the station up by its numeric ID instead
+		stationID, byID := 0, c.Param("id") != ""
+		if byID {
+			idParam, idType := splitStreamExtension(c.Param("id"))
+			id, err := strconv.Atoi(idParam)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Station ID must be an integer"})
+				return
+			}
+			stationID, stationName, extType = id, "id:"+idParam, idType
+		}
+		stationRequests.WithLabelValues(stationName).Inc()
+
+		timer := prometheus.NewTimer(apiLatency.WithLabelValues("/stream"))
+		defer timer.ObserveDuration()
+
+		if virtual != nil && !byID && stationName == config.VirtualStation {
+			virtual.serve(c, config)
+			return
+		}
+
+		forcedType := strings.ToLower(c.Query("ctype"))
+		if forcedType != "" && !forcedContentTypes[forcedType] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported ctype"})
+			return
+		}
+		if forcedType == "" {
+			forcedType = extType
+		}
+
+		// Fetch stations to get URL
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+
+		// Find station URL
+		var targetStation RadioStation
+		var found bool
+		if byID {
+			targetStation, found = findStationByID(stations, stationID)
+		} else {
+			targetStation, found = api.findStation(stations, stationName)
+		}
+		if !found {
+			if byID {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+			} else {
+				api.respondStationNotFound(c, stations, stationName)
+			}
+			return
+		}
+
+		// Fail fast while a repeatedly failing station cools down
+		if e := cooldowns.check(targetStation); e != nil {
+			respondLimit(c, e)
+			return
+		}
+
+		// Optionally refuse or replace a second stream from the same client
+		session, ok := sessions.start(c, targetStation)
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "Already streaming this station"})
+			return
+		}
+		defer sessions.end(session)
+
+		// Admin overrides take precedence over the catalog URL
+		if o, ok := overrides.lookup(targetStation); ok {
+			logger.Printf("Using URL override for %s: %s", targetStation.Name, o.URL)
+			targetStation.URL = o.URL
+		}
+
+		// Stations listed as .pls/.m3u point at the real stream indirectly
+		streamURL, err := resolveStationURL(c.Request.Context(), config, targetStation.URL)
+		if isStationURLError(err) {
+			respondStationURLError(c, logger, targetStation, err)
+			return
+		}
+		if err != nil {
+			streamErrors.Inc()
+			stationUptimes.markDown(targetStation)
+			cooldowns.failure(targetStation)
+			logger.Printf("Playlist resolution for %s failed: %v", targetStation.Name, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resolve station playlist"})
+			return
+		}
+		targetStation.URL = streamURL
+
+		// Cancelled when the client leaves or the origin stalls
+		upstreamCtx, cancelUpstream := context.WithCancel(c.Request.Context())
+		defer cancelUpstream()
+		session.bind(cancelUpstream)
+
+		// Ranged requests need their own upstream connection
+		if relay != nil && c.GetHeader("Range") == "" {
+			relay.serve(upstreamCtx, c, targetStation, forcedType)
+			return
+		}
+
+		// Create request to stream
+		req, err := http.NewRequestWithContext(upstreamCtx, "GET", targetStation.URL, nil)
+		if err != nil {
+			streamErrors.Inc()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stream request"})
+			return
+		}
+
+		// Set ICY/Shoutcast headers
+		req.Header.Set("Icy-MetaData", "1")
+		req.Header.Set("User-Agent", userAgents.pick(req.URL.Host))
+		forwardClientHeaders(config, c.Request, req)
+		// Seekable file "stations" honor ranges; live streams ignore them
+		if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+
+		if err := waitConnectJitter(c.Request.Context(), config); err != nil {
+			return
+		}
+
+		// Respect the origin's per-client connection limit
+		originHost := req.URL.Host
+		if err := origins.acquire(c.Request.Context(), originHost); err != nil {
+			logger.Printf("Origin %s unavailable for %s: %v", originHost, targetStation.Name, err)
+			var limitErr *limitError
+			if errors.As(err, &limitErr) {
+				respondLimit(c, limitErr)
+			} else {
+				c.Abort()
+			}
+			return
+		}
+		defer origins.release(originHost)
+
+		// The start timeout covers connecting through to the first audio byte
+		watchdog := newStreamWatchdog(config.StreamStartTimeout, cancelUpstream)
+		defer watchdog.stop()
+
+		// Execute request
+		connectStart := time.Now()
+		var movedTo string
+		client := redirects.client(config.StreamClient, &movedTo)
+		var streamResp *http.Response
+		// Retry connection errors and gateway errors with exponential
+		// backoff; nothing has been sent to the client yet
+		for attempt := 0; ; attempt++ {
+			streamResp, err = client.Do(req)
+			retryable := (err != nil && !isStationURLError(err)) || (err == nil && retryableStatus(streamResp.StatusCode))
+			if !retryable || attempt >= config.StreamRetries || upstreamCtx.Err() != nil {
+				break
+			}
+			reason := fmt.Sprint(err)
+			if err == nil {
+				reason = streamResp.Status
+				streamResp.Body.Close()
+			}
+			delay := config.StreamRetryDelay << attempt
+			logger.Printf("Connecting to %s failed (%s), retrying in %s", targetStation.Name, reason, delay)
+			watchdog.stop()
+			select {
+			case <-time.After(delay):
+			case <-upstreamCtx.Done():
+			}
+			watchdog.reset(config.StreamStartTimeout)
+		}
+		if isStationURLError(err) {
+			respondStationURLError(c, logger, targetStation, err)
+			return
+		}
+		if err != nil {
+			streamErrors.Inc()
+			stationUptimes.markDown(targetStation)
+			cooldowns.failure(targetStation)
+			logger.Printf("Stream connection error: %v", err)
+			if watchdog.expired() {
+				c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Timed out waiting for stream"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to stream"})
+			return
+		}
+		defer streamResp.Body.Close()
+
+		// Don't pipe an origin's error page to an audio player. A 416 answers
+		// the client's own Range header, so it is passed through.
+		if streamResp.StatusCode >= 400 && streamResp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+			streamErrors.Inc()
+			stationUptimes.markDown(targetStation)
+			cooldowns.failure(targetStation)
+			logger.Printf("Upstream for %s returned %s", targetStation.Name, streamResp.Status)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":           "Upstream returned " + streamResp.Status,
+				"upstream_status": streamResp.StatusCode,
+			})
+			return
+		}
+
+		// Log ICY headers for debugging
+		logICYHeaders(logger, streamResp)
+
+		// Wait for the first byte before committing to a response, then
+		// switch the watchdog over to the idle timeout
+		body := bufio.NewReaderSize(&idleReader{r: streamResp.Body, wd: watchdog, idle: config.StreamIdleTimeout}, config.UpstreamReadBuffer)
+		if _, err := body.Peek(1); err != nil && err != io.EOF {
+			streamErrors.Inc()
+			stationUptimes.markDown(targetStation)
+			cooldowns.failure(targetStation)
+			logger.Printf("Stream start error for %s: %v", targetStation.Name, err)
+			if watchdog.expired() {
+				c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Timed out waiting for stream"})
+				return
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read from stream"})
+			return
+		}
+		warnSlow(logger, config.SlowTTFBThreshold, "time to first byte", targetStation.Name, time.Since(connectStart))
+
+		// Down origins sometimes answer 200 with an HTML error page
+		if config.RejectHTML && isHTMLResponse(streamResp, body) {
+			streamErrors.Inc()
+			htmlResponses.Inc()
+			stationUptimes.markDown(targetStation)
+			cooldowns.failure(targetStation)
+			logger.Printf("Upstream for %s returned an HTML page instead of audio", targetStation.Name)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream returned an HTML page instead of audio"})
+			return
+		}
+		redirects.learn(targetStation, movedTo)
+
+		// Set appropriate headers
+		contentType := servedContentType(logger, targetStation.Name, getContentType(streamResp, peekHead(body)), forcedType)
+		c.Header("Content-Type", contentType)
+		copyICYInfo(c, streamResp.Header.Get)
+		if !passRangeHeaders(c, streamResp) {
+			c.Header("Transfer-Encoding", "chunked")
+		}
+		if c.Query("download") == "1" {
+			c.Header("Content-Disposition", downloadDisposition(targetStation.Name, contentType))
+		}
+		if config.StreamConnection != "" && c.Request.ProtoMajor == 1 {
+			c.Header("Connection", config.StreamConnection)
+		}
+
+		streamCodecs.WithLabelValues(codecLabel(contentType)).Inc()
+
+		// Track active streams
+		activeStreams.Inc()
+		defer activeStreams.Dec()
+
+		stationUptimes.markUp(targetStation)
+		cooldowns.success(targetStation)
+		stationUptimes.streamStarted(targetStation)
+		defer stationUptimes.streamEnded(targetStation)
+
+		// ICY-aware players get the metadata interleaved as sent; everyone
+		// else gets it stripped so it can't corrupt their audio
+		var source io.Reader = body
+		metaint := icyMetaint(streamResp)
+		interleaved := metaint > 0 && c.GetHeader("Icy-MetaData") == "1"
+		if interleaved {
+			c.Header("icy-metaint", strconv.Itoa(metaint))
+		} else if metaint > 0 {
+			source = newICYStripper(body, metaint, func(string) {})
+		}
+
+		// Feed a copy of the audio to the level meter, if one isn't running
+		// already and the stream carries no metadata
+		if levels != nil && !interleaved {
+			if tap, ok := levels.attach(targetStation.Name); ok {
+				defer tap.Close()
+				source = io.TeeReader(source, tap)
+			}
+		}
+
+		// Send the headers now so the player connects before audio arrives
+		c.Writer.WriteHeaderNow()
+		c.Writer.Flush()
+
+		// Stream with context cancellation support
+		done := make(chan struct{})
+		errChan := make(chan error, 1)
+		streamStart := time.Now()
+		var copied int64
+		defer func() {
+			<-done
+			recordStreamEnd(c, targetStation.Name, targetStation.URL, copied, streamStart)
+		}()
+
+		go func() {
+			defer close(done)
+
+			// Buffered for efficiency, but flushed on a timer so slow
+			// streams still reach the player promptly
+			out := newPeriodicFlusher(c.Writer, config.StreamBufferSize, config.FlushInterval)
+			out.onFirstByte = func() { recordStreamTTFB(c, targetStation.Name) }
+
+			var err error
+			copied, err = copyStream(out, source, make([]byte, config.StreamBufferSize))
+			if err != nil {
+				close(out.stop)
+				errChan <- err
+				return
+			}
+
+			// Flush any remaining data
+			out.Close()
+
+			// Trailers are only populated once the body has been read to EOF
+			forwardTrailers(logger, c.Writer, streamResp)
+		}()
+
+		// Wait for stream completion or context cancellation
+		select {
+		case err := <-errChan:
+			if session.wasReplaced() {
+				streamClientDisconnects.WithLabelValues(targetStation.Name).Inc()
+				logger.Printf("Stream for %s replaced by a newer connection from the same client", targetStation.Name)
+				return
+			}
+			if watchdog.expired() {
+				logger.Printf("Stream for %s stalled for %s, closing", targetStation.Name, config.StreamIdleTimeout)
+			} else {
+				logger.Printf("Stream error: %v", err)
+			}
+			streamErrors.Inc()
+			// A client hanging up is not an outage of the station
+			if c.Request.Context().Err() == nil {
+				streamUpstreamErrors.WithLabelValues(targetStation.Name).Inc()
+				stationUptimes.markDown(targetStation)
+				cooldowns.failure(targetStation)
+			} else {
+				streamClientDisconnects.WithLabelValues(targetStation.Name).Inc()
+			}
+			c.AbortWithStatus(http.StatusInternalServerError)
+		case <-c.Done():
+			streamClientDisconnects.WithLabelValues(targetStation.Name).Inc()
+			logger.Println("Stream cancelled by client")
+		case <-done:
+			streamCompletions.WithLabelValues(targetStation.Name).Inc()
+			logger.Println("Stream completed")
+		}
+	}
+}
+
+// Upstream ICY headers describing the station, passed on to players
+var icyInfoHeaders = []string{"icy-name", "icy-genre", "icy-br", "icy-description", "icy-url"}
+
+// Helper to log ICY/Shoutcast headers
+func logICYHeaders(logger *log.Logger, resp *http.Response) {
+	headers := append([]string{"icy-pub"}, icyInfoHeaders...)
+	headers = append(headers, "icy-metaint", "Content-Type")
+
+	logger.Println("Stream Headers:")
+	for _, header := range headers {
+		if val := resp.Header.Get(header); val != "" {
+			logger.Printf("%s: %s", header, val)
+		}
+	}
+}
+
+// Copy the station's ICY info headers onto our response. get looks a
+// header up by its lowercase name. Control characters are dropped so an
+// upstream can't inject headers of its own.
+func copyICYInfo(c *gin.Context, get func(string) string) {
+	for _, header := range icyInfoHeaders {
+		val := strings.Map(func(r rune) rune {
+			switch {
+			case r == '\t':
+				return ' '
+			case r < 0x20 || r == 0x7f:
+				return -1
+			}
+			return r
+		}, get(header))
+		if val = strings.TrimSpace(val); val != "" {
+			c.Header(header, val)
+		}
+	}
+}
+
+// Log and relay trailers sent after a chunked upstream body
+func forwardTrailers(logger *log.Logger, w http.ResponseWriter, resp *http.Response) {
+	for key, values := range resp.Trailer {
+		for _, val := range values {
+			logger.Printf("Stream trailer %s: %s", key, val)
+			w.Header().Add(http.TrailerPrefix+key, val)
+		}
+	}
+}
+
+// Mirror range support and length from a seekable upstream, answering 206
+// when it did. Returns false for live streams of unknown length.
+func passRangeHeaders(c *gin.Context, resp *http.Response) bool {
+	if accept := resp.Header.Get("Accept-Ranges"); accept != "" {
+		c.Header("Accept-Ranges", accept)
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		c.Header("Content-Range", resp.Header.Get("Content-Range"))
+		c.Status(http.StatusPartialContent)
+	}
+	if resp.ContentLength < 0 {
+		return false
+	}
+	c.Header("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	return true
+}
+
+// Wait a random part of -connect-jitter before dialing an origin, to
+// spread out reconnection storms after an outage
+func waitConnectJitter(ctx context.Context, config Config) error {
+	if config.ConnectJitter <= 0 {
+		return nil
+	}
+	delay := time.Duration(rand.Int63n(int64(config.ConnectJitter)))
+	connectJitter.Observe(delay.Seconds())
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Gateway errors from an origin are usually transient
+func retryableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// Extensions accepted on /stream/:station so players that go by the URL
+// recognize the format, e.g. /stream/jazz.mp3
+var streamExtensions = map[string]string{
+	".mp3": "audio/mpeg",
+	".aac": "audio/aac",
+	".ogg": "audio/ogg",
+}
+
+// Strip a recognized extension from a stream path param and return the
+// content type it selects, or "" when there is none
+func splitStreamExtension(param string) (string, string) {
+	ext := strings.ToLower(path.Ext(param))
+	if contentType, ok := streamExtensions[ext]; ok {
+		return param[:len(param)-len(ext)], contentType
+	}
+	return param, ""
+}
+
+// Content types a client may force with ?ctype=. Forcing only relabels the
+// response; the upstream audio is passed through as-is, never transcoded.
+var forcedContentTypes = map[string]bool{
+	"audio/mpeg":      true,
+	"audio/aac":       true,
+	"audio/aacp":      true,
+	"audio/ogg":       true,
+	"audio/opus":      true,
+	"audio/flac":      true,
+	"audio/wav":       true,
+	"application/ogg": true,
+}
+
+// Feed error for an origin that answered with an HTML page
+var errHTMLResponse = errors.New("upstream returned an HTML page instead of audio")
+
+// Check the declared type and the first bytes of the body for an HTML page
+func isHTMLResponse(resp *http.Response, body *bufio.Reader) bool {
+	if strings.HasPrefix(strings.ToLower(resp.Header.Get("Content-Type")), "text/html") {
+		return true
+	}
+	head, _ := body.Peek(512)
+	return strings.HasPrefix(http.DetectContentType(head), "text/html")
 }
 
-func healthCheckHandler() gin.HandlerFunc {
-    return func(c *gin.Context) {
-        c.JSON(http.StatusOK, gin.H{
-            "status": "healthy",
-            "time":   time.Now().Format(time.RFC3339),
-        })
-    }
+// The content type to serve: the extension's type when one was requested,
+// otherwise the detected one. Counts and warns about stations whose type
+// couldn't be determined.
+func servedContentType(logger *log.Logger, station, detected, forcedType string) string {
+	if forcedType != "" {
+		return forcedType
+	}
+	if detected == "application/octet-stream" {
+		contentTypeFallbacks.WithLabelValues(station).Inc()
+		if contentTypeWarnings.allow(station) {
+			logger.Printf("Warning: could not determine content type for station %s, serving application/octet-stream", station)
+		}
+	}
+	return detected
 }
 
-func getStationsHandler(config Config, logger *log.Logger) gin.HandlerFunc {
-    return func(c *gin.Context) {
-        timer := prometheus.NewTimer(apiLatency.WithLabelValues("/stations"))
-        defer timer.ObserveDuration()
-        
-        resp, err := http.Get(config.APIEndpoint)
-        if err != nil {
-            logger.Printf("Error fetching stations: %v", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stations"})
-            return
-        }
-        defer resp.Body.Close()
-        
-        var stations []RadioStation
-        if err := json.NewDecoder(resp.Body).Decode(&stations); err != nil {
-            logger.Printf("Error parsing stations: %v", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse stations"})
-            return
-        }
-        
-        var response []StationResponse
-        for _, station := range stations {
-            response = append(response, StationResponse{
-                Name: station.Name,
-            })
-        }
-        
-        logger.Printf("Successfully returned %d stations", len(response))
-        c.JSON(http.StatusOK, response)
-    }
+// Bounded codec label for a content type
+func codecLabel(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext, ok := downloadExtensions[strings.ToLower(mediaType)]; ok {
+		return strings.TrimPrefix(ext, ".")
+	}
+	return "other"
 }
 
-func streamStationHandler(config Config, logger *log.Logger) gin.HandlerFunc {
-    return func(c *gin.Context) {
-        stationName := c.Param("station")
-        logger.Printf("Streaming request for station: %s", stationName)
-        
-        // Increment request counter for this station
-        stationRequests.WithLabelValues(stationName).Inc()
-        
-        timer := prometheus.NewTimer(apiLatency.WithLabelValues("/stream"))
-        defer timer.ObserveDuration()
-        
-        resp, err := http.Get(config.APIEndpoint)
-        if err != nil {
-            logger.Printf("Error fetching stations: %v", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stations"})
-            return
-        }
-        defer resp.Body.Close()
-        
-        var stations []RadioStation
-        if err := json.NewDecoder(resp.Body).Decode(&stations); err != nil {
-            logger.Printf("Error parsing stations: %v", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse stations"})
-            return
-        }
-        
-        var targetStation RadioStation
-        found := false
-        for _, station := range stations {
-            if strings.EqualFold(station.Name, stationName) {
-                targetStation = station
-                found = true
-                break
-            }
-        }
-        
-        if !found {
-            logger.Printf("Station not found: %s", stationName)
-            c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
-            return
-        }
-        
-        streamResp, err := http.Get(targetStation.URL)
-        if err != nil {
-            streamErrors.Inc()
-            logger.Printf("Error connecting to radio stream: %v", err)
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to radio stream"})
-            return
-        }
-        defer streamResp.Body.Close()
-        
-        c.Header("Content-Type", streamResp.Header.Get("Content-Type"))
-        c.Header("Transfer-Encoding", "chunked")
-        
-        activeStreams.Inc()
-        defer activeStreams.Dec()
-        
-        _, err = io.Copy(c.Writer, streamResp.Body)
-        if err != nil {
-            streamErrors.Inc()
-            logger.Printf("Streaming error: %v", err)
-        }
-    }
+// Determine content type from the upstream header, falling back to
+// sniffing the first bytes of the stream
+func getContentType(resp *http.Response, head []byte) string {
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		return contentType
+	}
+	if sniffed := sniffAudioType(head); sniffed != "" {
+		return sniffed
+	}
+	return "application/octet-stream"
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Parse a comma-separated list of CIDRs; bare addresses are single hosts
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Restricts /metrics to allowed source networks and a per-client scrape
+// rate. Uses the connection's address, not X-Forwarded-For, so the
+// allowlist can't be spoofed.
+func metricsGuard(config Config) gin.HandlerFunc {
+	var (
+		mu      sync.Mutex
+		windows = make(map[string]*scrapeWindow)
+	)
+
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.RemoteIP())
+		if len(config.MetricsAllowCIDRs) > 0 && !ipInNets(ip, config.MetricsAllowCIDRs) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		if config.MetricsRateLimit <= 0 {
+			return
+		}
+
+		mu.Lock()
+		now := time.Now()
+		w, ok := windows[c.RemoteIP()]
+		if !ok || now.Sub(w.start) >= time.Minute {
+			// Drop other clients' finished windows so the map stays small
+			for key, old := range windows {
+				if now.Sub(old.start) >= time.Minute {
+					delete(windows, key)
+				}
+			}
+			w = &scrapeWindow{start: now}
+			windows[c.RemoteIP()] = w
+		}
+		w.count++
+		count, retryAfter := w.count, w.start.Add(time.Minute).Sub(now)
+		mu.Unlock()
+
+		if count > config.MetricsRateLimit {
+			respondLimit(c, &limitError{
+				Limit:      "metrics_scrapes_per_minute",
+				Current:    count,
+				Max:        config.MetricsRateLimit,
+				RetryAfter: retryAfter,
+				PerClient:  true,
+			})
+		}
+	}
+}
+
+type scrapeWindow struct {
+	start time.Time
+	count int
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errBlockedAddress = errors.New("destination address is not allowed")
+
+// Addresses an arbitrary user-supplied URL must never reach
+func isBlockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast()
+}
+
+// Dialer hook that rejects connections to internal addresses. Checking at
+// dial time (rather than when parsing the URL) also covers redirects and
+// DNS answers that change between lookup and connect.
+func blockInternalDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isBlockedIP(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+	return nil
+}
+
+// HTTP client for fetching URLs supplied by API callers
+func newGuardedClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: blockInternalDial,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+}
+
+// Check that a caller-supplied URL is an absolute http(s) URL
+func validateOutboundURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+var (
+	errBlockedStation    = errors.New("station URL is not allowed")
+	errInvalidStationURL = errors.New("station URL is invalid")
+)
+
+// Which addresses station streams may connect to. Allow entries win over
+// deny entries; anything else is refused if isBlockedIP rejects it. The
+// lists are swapped as a whole on reload, so the transports built at
+// startup share the policy by pointer. A nil policy only applies
+// isBlockedIP.
+type addressPolicy struct {
+	nets atomic.Pointer[addressNets]
+}
+
+type addressNets struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newAddressPolicy(allow, deny []*net.IPNet) *addressPolicy {
+	p := &addressPolicy{}
+	p.set(allow, deny)
+	return p
+}
+
+func (p *addressPolicy) set(allow, deny []*net.IPNet) {
+	p.nets.Store(&addressNets{allow: allow, deny: deny})
+}
+
+func (p *addressPolicy) current() addressNets {
+	if p == nil {
+		return addressNets{}
+	}
+	if nets := p.nets.Load(); nets != nil {
+		return *nets
+	}
+	return addressNets{}
+}
+
+func (p *addressPolicy) blocked(ip net.IP) bool {
+	nets := p.current()
+	for _, n := range nets.allow {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	for _, n := range nets.deny {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return isBlockedIP(ip)
+}
+
+// Dialer hook applying the policy to the address actually connected to
+func (p *addressPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || p.blocked(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+	return nil
+}
+
+// Resolve a relative station URL, such as a bare mount point, against
+// -station-base-url. Absolute URLs are returned unchanged.
+func absoluteStationURL(base *url.URL, raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidStationURL, err)
+	}
+	if u.IsAbs() {
+		return raw, nil
+	}
+	if base == nil {
+		return "", fmt.Errorf("%w: %q is relative and no station base URL is set", errInvalidStationURL, raw)
+	}
+	resolved := base.ResolveReference(u)
+	if resolved.Host == "" {
+		return "", fmt.Errorf("%w: %q has no host", errInvalidStationURL, resolved)
+	}
+	return resolved.String(), nil
+}
+
+// Make a station URL absolute and check its scheme, then follow any
+// playlist to the stream and check that too. Addresses are checked by the
+// stream transport on every request.
+func resolveStationURL(ctx context.Context, config Config, raw string) (string, error) {
+	raw, err := absoluteStationURL(config.StationBaseURL, raw)
+	if err != nil {
+		return "", err
+	}
+	if _, err := validateOutboundURL(raw); err != nil {
+		return "", fmt.Errorf("%w: %v", errBlockedStation, err)
+	}
+	streamURL, err := resolvePlaylist(ctx, config.StreamClient, raw, config.PlaylistMaxDepth)
+	if err != nil {
+		return "", err
+	}
+	if _, err := validateOutboundURL(streamURL); err != nil {
+		return "", fmt.Errorf("%w: %v", errBlockedStation, err)
+	}
+	return streamURL, nil
+}
+
+// Redirect policy for station requests: the default limit of 10, and only
+// to http(s) URLs. The target's address is checked like any other request.
+func checkStationRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if _, err := validateOutboundURL(req.URL.String()); err != nil {
+		return fmt.Errorf("%w: %v", errBlockedStation, err)
+	}
+	return nil
+}
+
+// Resolve host and refuse it if any of its addresses is blocked
+func (p *addressPolicy) checkHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if p.blocked(addr.IP) {
+			return fmt.Errorf("%w: %s", errBlockedAddress, addr.IP)
+		}
+	}
+	return nil
+}
+
+// Report whether err means the station's URL cannot be fetched at all
+func isStationURLError(err error) bool {
+	return errors.Is(err, errBlockedStation) || errors.Is(err, errBlockedAddress) || errors.Is(err, errInvalidStationURL)
+}
+
+func respondStationURLError(c *gin.Context, logger *log.Logger, station RadioStation, err error) {
+	logger.Printf("Refusing to stream %s: %v", station.Name, err)
+	if errors.Is(err, errInvalidStationURL) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Station URL is invalid"})
+		return
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Station URL is not allowed"})
+}
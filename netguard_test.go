@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Test config with the default address policy, which allows no internal
+// networks
+func guardedConfig(api string) Config {
+	config := testConfig(api)
+	config.StreamAddresses = newAddressPolicy(nil, nil)
+	config.StreamClient, config.APIClient = newUpstreamClients(config)
+	return config
+}
+
+func TestStationURLsToInternalAddressesAreBlocked(t *testing.T) {
+	upstream := endlessUpstream(t)
+	port := upstream.URL[strings.LastIndex(upstream.URL, ":"):]
+	catalog := catalogServer(t,
+		RadioStation{ID: 1, Name: "Loopback", URL: upstream.URL + "/live"},
+		RadioStation{ID: 2, Name: "Localhost", URL: "http://localhost" + port + "/live"},
+		RadioStation{ID: 3, Name: "Private", URL: "http://10.1.2.3/live"},
+		RadioStation{ID: 4, Name: "Metadata", URL: "http://169.254.169.254/latest/"},
+		RadioStation{ID: 5, Name: "Scheme", URL: "file:///etc/passwd"},
+	)
+	srv := streamTestServer(t, guardedConfig(catalog.URL))
+
+	for _, station := range []string{"Loopback", "Localhost", "Private", "Metadata", "Scheme"} {
+		resp, err := http.Get(srv.URL + "/stream/" + station)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403", station, resp.StatusCode)
+		}
+	}
+}
+
+func TestSideFetchesAreGuarded(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("internal server reached at %s", r.URL)
+	}))
+	defer internal.Close()
+
+	config := guardedConfig("http://catalog.example/")
+	clients := map[string]*http.Client{
+		"logos":           newLogoCache(config, testLogger).client,
+		"catalog webhook": newCatalogWatcher(config, testLogger).client,
+		"scrobble":        newTitleTracker(config, testLogger).client,
+	}
+	for name, client := range clients {
+		for _, target := range []string{internal.URL + "/", "http://10.1.2.3/"} {
+			resp, err := client.Get(target)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, errBlockedAddress) {
+				t.Errorf("%s: GET %s err = %v, want errBlockedAddress", name, target, err)
+			}
+		}
+	}
+}
+
+func TestProxiedStationsAreChecked(t *testing.T) {
+	// A forward proxy on loopback that fakes a public origin: a playlist
+	// and a redirect both pointing at a private address
+	var (
+		mu      sync.Mutex
+		proxied []string
+	)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/list.m3u":
+			w.Write([]byte("http://10.1.2.3/live\n"))
+		case "/moved":
+			http.Redirect(w, r, "http://10.1.2.3/live", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte{0xFF, 0xFB, 0x90, 0x64})
+		}
+	}))
+	defer proxy.Close()
+	proxyURL, err := parseProxyURL(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const public = "http://192.0.2.10"
+	catalog := catalogServer(t,
+		RadioStation{ID: 1, Name: "Public", URL: public + "/live"},
+		RadioStation{ID: 2, Name: "Private", URL: "http://10.1.2.3/live"},
+		RadioStation{ID: 3, Name: "Playlist", URL: public + "/list.m3u"},
+		RadioStation{ID: 4, Name: "Redirect", URL: public + "/moved"},
+	)
+	config := testConfig(catalog.URL)
+	config.StreamAddresses = newAddressPolicy(nil, []*net.IPNet{{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}})
+	config.UpstreamProxy = proxyURL
+	config.StreamClient, config.APIClient = newUpstreamClients(config)
+
+	req := httptest.NewRequest(http.MethodGet, public+"/live", nil)
+	if got, err := config.StreamClient.Transport.(*http.Transport).Proxy(req); err != nil || got.String() != proxyURL.String() {
+		t.Fatalf("Proxy(%s) = %v, %v, want %s", req.URL, got, err, proxyURL)
+	}
+
+	srv := streamTestServer(t, config)
+	tests := []struct {
+		station     string
+		want        int
+		wantProxied int
+	}{
+		{"Public", http.StatusOK, 1},
+		{"Private", http.StatusForbidden, 0},
+		{"Playlist", http.StatusForbidden, 1},
+		{"Redirect", http.StatusForbidden, 1},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		proxied = nil
+		mu.Unlock()
+		resp, err := http.Get(srv.URL + "/stream/" + tt.station)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.station, resp.StatusCode, tt.want)
+		}
+		mu.Lock()
+		if len(proxied) != tt.wantProxied {
+			t.Errorf("%s: proxy saw %v, want %d requests", tt.station, proxied, tt.wantProxied)
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var originConnections = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "radio_origin_connections",
+		Help: "The number of open stream connections per origin host",
+	},
+	[]string{"origin"},
+)
+
+// Caps simultaneous stream connections to any single origin host, since
+// Icecast/Shoutcast servers commonly ban IPs that exceed a per-client limit
+type originLimiter struct {
+	max          int
+	queueTimeout time.Duration
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newOriginLimiter(config Config) *originLimiter {
+	return &originLimiter{
+		max:          config.MaxConnsPerOrigin,
+		queueTimeout: config.OriginQueueTimeout,
+		slots:        make(map[string]chan struct{}),
+	}
+}
+
+func (l *originLimiter) hostSlots(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.max)
+		l.slots[host] = slots
+	}
+	return slots
+}
+
+// Wait briefly for a free connection slot to the origin host
+func (l *originLimiter) acquire(ctx context.Context, host string) error {
+	if l.max > 0 {
+		slots := l.hostSlots(host)
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+
+		select {
+		case slots <- struct{}{}:
+		case <-timer.C:
+			return &limitError{
+				Limit:      "origin_connections",
+				Current:    len(slots),
+				Max:        l.max,
+				RetryAfter: 5 * time.Second,
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	originConnections.WithLabelValues(host).Inc()
+	return nil
+}
+
+func (l *originLimiter) release(host string) {
+	originConnections.WithLabelValues(host).Dec()
+	if l.max > 0 {
+		<-l.hostSlots(host)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Temporary URL override for a station, installed via the admin API
+type urlOverride struct {
+	Key       string    `json:"key"`
+	Name      string    `json:"name,omitempty"`
+	ID        int       `json:"id,omitempty"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// In-memory set of station URL overrides, each expiring after the TTL
+type overrideStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]urlOverride
+}
+
+func newOverrideStore(ttl time.Duration) *overrideStore {
+	return &overrideStore{
+		ttl:     ttl,
+		entries: make(map[string]urlOverride),
+	}
+}
+
+func overrideKey(name string, id int) string {
+	if id != 0 {
+		return fmt.Sprintf("id:%d", id)
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(name))
+}
+
+func (s *overrideStore) set(name string, id int, target string) urlOverride {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	o := urlOverride{
+		Key:       overrideKey(name, id),
+		Name:      name,
+		ID:        id,
+		URL:       target,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	s.entries[o.Key] = o
+	return o
+}
+
+func (s *overrideStore) remove(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.entries[key]
+	delete(s.entries, key)
+	return ok
+}
+
+// Drop expired entries; caller must hold the lock
+func (s *overrideStore) expireLocked(now time.Time) {
+	for key, o := range s.entries {
+		if !now.Before(o.ExpiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *overrideStore) list() []urlOverride {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked(time.Now())
+	list := make([]urlOverride, 0, len(s.entries))
+	for _, o := range s.entries {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// Find an active override for a station, preferring an ID match over a name match
+func (s *overrideStore) lookup(station RadioStation) (urlOverride, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked(time.Now())
+	if station.ID != 0 {
+		if o, ok := s.entries[overrideKey("", station.ID)]; ok {
+			return o, true
+		}
+	}
+	o, ok := s.entries[overrideKey(station.Name, 0)]
+	return o, ok
+}
+
+type overrideRequest struct {
+	Name string `json:"name"`
+	ID   int    `json:"id"`
+	URL  string `json:"url"`
+}
+
+func listOverridesHandler(overrides *overrideStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, overrides.list())
+	}
+}
+
+func setOverrideHandler(overrides *overrideStore, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req overrideRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			if respondBodyTooLarge(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid override request"})
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" && req.ID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Either name or id is required"})
+			return
+		}
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http or https URL"})
+			return
+		}
+
+		o := overrides.set(req.Name, req.ID, req.URL)
+		logger.Printf("Installed URL override %s -> %s (expires %s)", o.Key, o.URL, o.ExpiresAt.Format(time.RFC3339))
+		c.JSON(http.StatusCreated, o)
+	}
+}
+
+// Clear an override by ?id= or ?name=
+func deleteOverrideHandler(overrides *overrideStore, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var key string
+		if idParam := c.Query("id"); idParam != "" {
+			id, err := strconv.Atoi(idParam)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid station id"})
+				return
+			}
+			key = overrideKey("", id)
+		} else if name := c.Query("name"); name != "" {
+			key = overrideKey(name, 0)
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Either name or id is required"})
+			return
+		}
+
+		if !overrides.remove(key) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Override not found"})
+			return
+		}
+		logger.Printf("Removed URL override %s", key)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var (
+	errPlaylistDepth = errors.New("playlist nesting exceeds maximum depth")
+	errPlaylistLoop  = errors.New("playlist loop detected")
+	errPlaylistEmpty = errors.New("playlist contains no stream entries")
+)
+
+// Largest playlist body we are willing to parse
+const maxPlaylistBytes = 64 * 1024
+
+func isPlaylistURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".pls", ".m3u":
+		return true
+	}
+	return false
+}
+
+// Follow .pls/.m3u station URLs to the audio stream they point at. Nested
+// playlists are followed up to maxDepth levels and revisiting a URL is
+// treated as a loop. A maxDepth of 0 disables following entirely.
+func resolvePlaylist(ctx context.Context, client *http.Client, streamURL string, maxDepth int) (string, error) {
+	if maxDepth <= 0 {
+		return streamURL, nil
+	}
+
+	visited := make(map[string]bool)
+	current := streamURL
+	for depth := 0; isPlaylistURL(current); depth++ {
+		if visited[current] {
+			return "", fmt.Errorf("%w at %s", errPlaylistLoop, current)
+		}
+		if depth >= maxDepth {
+			return "", fmt.Errorf("%w (%d) at %s", errPlaylistDepth, maxDepth, current)
+		}
+		visited[current] = true
+
+		next, err := fetchPlaylistEntry(ctx, client, current)
+		if err != nil {
+			return "", err
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// Fetch a playlist and return its first entry, resolved against the playlist URL
+func fetchPlaylistEntry(ctx context.Context, client *http.Client, playlistURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", playlistURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("playlist %s returned status %d", playlistURL, resp.StatusCode)
+	}
+
+	entry, err := firstPlaylistEntry(io.LimitReader(resp.Body, maxPlaylistBytes))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", playlistURL, err)
+	}
+
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(entry)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Return the first stream URL in a PLS (FileN=...) or M3U playlist
+func firstPlaylistEntry(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		if key, val, ok := strings.Cut(line, "="); ok && isPLSKey(key) {
+			if strings.HasPrefix(strings.ToLower(key), "file") {
+				return strings.TrimSpace(val), nil
+			}
+			continue
+		}
+		return line, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errPlaylistEmpty
+}
+
+// PLS keys are plain words like File1 or NumberOfEntries, which keeps M3U
+// URLs with query strings from being mistaken for key=value pairs
+func isPLSKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
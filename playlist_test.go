@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Serves /loop.m3u pointing at itself and a chain a.pls -> b.m3u ->
+// c.m3u -> /live
+func playlistServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop.m3u":
+			fmt.Fprintf(w, "#EXTM3U\n%s/loop.m3u\n", srv.URL)
+		case "/a.pls":
+			fmt.Fprint(w, "[playlist]\nNumberOfEntries=1\nFile1=b.m3u\n")
+		case "/b.m3u":
+			fmt.Fprint(w, "c.m3u\n")
+		case "/c.m3u":
+			fmt.Fprint(w, "/live\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestResolvePlaylist(t *testing.T) {
+	srv := playlistServer(t)
+
+	tests := []struct {
+		name     string
+		start    string
+		maxDepth int
+		want     string
+		wantErr  error
+	}{
+		{"self-referential", "/loop.m3u", 5, "", errPlaylistLoop},
+		{"nested beyond the limit", "/a.pls", 2, "", errPlaylistDepth},
+		{"nested within the limit", "/a.pls", 3, "/live", nil},
+		{"following disabled", "/a.pls", 0, "/a.pls", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePlaylist(context.Background(), srv.Client(), srv.URL+tt.start, tt.maxDepth)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != srv.URL+tt.want {
+				t.Errorf("resolved to %s, want %s", got, srv.URL+tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamPlaylistLoopIsBadGateway(t *testing.T) {
+	srv := playlistServer(t)
+	catalog := catalogServer(t,
+		RadioStation{ID: 1, Name: "Loop", URL: srv.URL + "/loop.m3u"},
+		RadioStation{ID: 2, Name: "Deep", URL: srv.URL + "/a.pls"},
+	)
+	proxy := streamTestServer(t, testConfig(catalog.URL))
+
+	for _, station := range []string{"Loop", "Deep"} {
+		resp, err := http.Get(proxy.URL + "/stream/" + station)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("%s: status = %d, want 502", station, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Playlist formats served at /playlist/:station.<ext> and
+// /playlist/id/:id.<ext>, by extension
+var playlistTypes = map[string]string{
+	".m3u": "audio/x-mpegurl",
+	".pls": "audio/x-scpls",
+}
+
+// Serve a one-entry M3U or PLS playlist pointing at our own stream URL,
+// for players that open playlists rather than raw streams
+func playlistHandler(logger *log.Logger, api *stationsAPI) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		file, byID := c.Param("file"), c.Param("id") != ""
+		if byID {
+			file = c.Param("id")
+		}
+		ext := strings.ToLower(path.Ext(file))
+		contentType, ok := playlistTypes[ext]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+		stationName := file[:len(file)-len(ext)]
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		station, found := api.findPathStation(c, stations, stationName, byID)
+		if !found {
+			return
+		}
+
+		target := requestBaseURL(c) + "/stream/" + stationPathSegment(station)
+		// Both formats are line based, so a title must stay on one line
+		title := strings.Join(strings.Fields(station.Name), " ")
+		var body string
+		if ext == ".pls" {
+			body = fmt.Sprintf("[playlist]\nNumberOfEntries=1\nFile1=%s\nTitle1=%s\nLength1=-1\nVersion=2\n", target, title)
+		} else {
+			body = fmt.Sprintf("#EXTM3U\n#EXTINF:-1,%s\n%s\n", title, target)
+		}
+
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": sanitizeFilename(station.Name) + ext,
+		}))
+		c.Data(http.StatusOK, contentType, []byte(body))
+	}
+}
+
+// Escaped path segment identifying a station, preferring its slug. Stations
+// sharing a name are addressed by ID, as id/<id>, so each link reaches its
+// own station.
+func stationPathSegment(station RadioStation) string {
+	if station.Duplicate && station.ID != 0 {
+		return "id/" + strconv.Itoa(station.ID)
+	}
+	if station.Slug != "" {
+		return url.PathEscape(station.Slug)
+	}
+	return url.PathEscape(station.Name)
+}
+
+// Find the station a path names, by ID under /<route>/id/ and by name
+// otherwise. Responds with the error when there is none.
+func (a *stationsAPI) findPathStation(c *gin.Context, stations []RadioStation, name string, byID bool) (RadioStation, bool) {
+	if !byID {
+		station, found := a.findStation(stations, name)
+		if !found {
+			a.respondStationNotFound(c, stations, name)
+		}
+		return station, found
+	}
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Station ID must be an integer"})
+		return RadioStation{}, false
+	}
+	station, found := findStationByID(stations, id)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+	}
+	return station, found
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+func TestDuplicateStationLinksUseIDs(t *testing.T) {
+	catalog := catalogServer(t,
+		RadioStation{ID: 1, Name: "Jazz", URL: "http://one.example/jazz"},
+		RadioStation{ID: 2, Name: "Jazz", URL: "http://two.example/jazz"},
+		RadioStation{ID: 3, Name: "Rock FM", URL: "http://rock.example/live"},
+	)
+	config := testConfig(catalog.URL)
+	api := newStationsAPI(config, testLogger)
+
+	r := gin.New()
+	r.GET("/", indexHandler(api, testLogger))
+	r.GET("/stations", getStationsHandler(api, testLogger, nil, newLogoCache(config, testLogger)))
+	r.POST("/stations/resolve", resolveStationsHandler(api, testLogger, nil))
+	qr := qrCodeHandler(config, testLogger, api)
+	r.GET("/qr/:file", qr)
+	r.GET("/qr/id/:id", qr)
+	playlist := playlistHandler(testLogger, api)
+	r.GET("/playlist/:file", playlist)
+	r.GET("/playlist/id/:id", playlist)
+
+	get := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status = %d, body %s", method, target, w.Code, w.Body)
+		}
+		return w
+	}
+	const base = "http://example.com"
+
+	var listed []StationResponse
+	json.Unmarshal(get(http.MethodGet, "/stations?format=full", "").Body.Bytes(), &listed)
+	want := map[int][2]string{
+		1: {base + "/stream/id/1", base + "/playlist/id/1.m3u"},
+		2: {base + "/stream/id/2", base + "/playlist/id/2.m3u"},
+		3: {base + "/stream/rock-fm", base + "/playlist/rock-fm.m3u"},
+	}
+	for _, entry := range listed {
+		if got := [2]string{entry.StreamURL, entry.PlaylistURL}; got != want[entry.ID] {
+			t.Errorf("station %d links = %v, want %v", entry.ID, got, want[entry.ID])
+		}
+	}
+
+	if body := get(http.MethodGet, "/playlist/id/2.m3u", "").Body.String(); !strings.Contains(body, base+"/stream/id/2\n") {
+		t.Errorf("playlist for ID 2 = %q, want a link to /stream/id/2", body)
+	}
+
+	var resolved []resolvedStation
+	json.Unmarshal(get(http.MethodPost, "/stations/resolve", `["Jazz", 2]`).Body.Bytes(), &resolved)
+	if len(resolved) != 2 || resolved[0].StreamURL != base+"/stream/id/1" || resolved[1].StreamURL != base+"/stream/id/2" {
+		t.Errorf("resolved = %+v, want links to /stream/id/1 and /stream/id/2", resolved)
+	}
+
+	// go-qrcode output is deterministic, so compare against the expected link
+	for path, link := range map[string]string{
+		"/qr/id/2.png":    base + "/playlist/id/2.m3u",
+		"/qr/Rock FM.png": base + "/playlist/rock-fm.m3u",
+	} {
+		want, err := qrcode.Encode(link, qrLevels[config.QRLevel], config.QRSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := get(http.MethodGet, strings.ReplaceAll(path, " ", "%20"), "")
+		if w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), want) {
+			t.Errorf("%s: Content-Type %q, want a PNG encoding %s", path, w.Header().Get("Content-Type"), link)
+		}
+	}
+
+	index := get(http.MethodGet, "/", "").Body.String()
+	for _, link := range []string{`href="/stream/id/1"`, `href="/stream/id/2"`, `href="/playlist/id/2.m3u"`, `href="/stream/rock-fm"`} {
+		if !strings.Contains(index, link) {
+			t.Errorf("index has no %s", link)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Profiling is gated behind -pprof because profiles expose internals and
+// can be expensive to take; it is never mounted by default.
+func pprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Serve pprof on its own listener when -pprof-addr is set, otherwise under
+// /debug/pprof on the main router
+func setupPprof(config Config, logger *log.Logger, r *gin.Engine) {
+	mux := pprofMux()
+	if config.PprofAddr == "" {
+		r.Any("/debug/pprof/*path", gin.WrapH(mux))
+		return
+	}
+	go func() {
+		logger.Printf("Serving pprof on %s", config.PprofAddr)
+		if err := http.ListenAndServe(config.PprofAddr, mux); err != nil {
+			logger.Printf("pprof listener failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var probeSweepDuration = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "radio_probe_sweep_duration_seconds",
+	Help: "Time taken by the most recent full availability sweep",
+})
+
+// Result of the most recent availability probe for a station
+type stationProbe struct {
+	Available bool      `json:"available"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Periodically probes every station's stream URL with a lightweight
+// HEAD (or header-only GET) request and caches whether it answered.
+type availabilityProber struct {
+	api       *stationsAPI
+	overrides *overrideStore
+	cooldowns *cooldownTracker
+	logger    *log.Logger
+	interval  time.Duration
+	workers   int
+	jitter    time.Duration
+	client    *http.Client
+	userAgent string
+	baseURL   *url.URL
+
+	mu      sync.RWMutex
+	results map[string]stationProbe
+}
+
+func newAvailabilityProber(config Config, logger *log.Logger, api *stationsAPI, overrides *overrideStore, cooldowns *cooldownTracker) *availabilityProber {
+	return &availabilityProber{
+		api:       api,
+		overrides: overrides,
+		cooldowns: cooldowns,
+		logger:    logger,
+		interval:  config.ProbeInterval,
+		workers:   config.ProbeConcurrency,
+		jitter:    config.ProbeOriginJitter,
+		client:    &http.Client{Transport: config.StreamClient.Transport, Timeout: config.ProbeTimeout},
+		userAgent: primaryUserAgent(config),
+		baseURL:   config.StationBaseURL,
+		results:   make(map[string]stationProbe),
+	}
+}
+
+func (p *availabilityProber) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.sweep(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Probe every station with a bounded pool of workers. Each origin's
+// stations are queued one after another with a random pause in between,
+// so a host carrying many stations isn't hit by all of them at once.
+func (p *availabilityProber) sweep(ctx context.Context) {
+	start := time.Now()
+	stations, err := p.api.fetch(ctx)
+	if err != nil {
+		p.logger.Printf("Availability sweep skipped: %v", err)
+		return
+	}
+
+	byOrigin := make(map[string][]RadioStation)
+	for _, station := range stations {
+		if o, ok := p.overrides.lookup(station); ok {
+			station.URL = o.URL
+		}
+		host := ""
+		if u, err := url.Parse(station.URL); err == nil {
+			host = u.Host
+		}
+		byOrigin[host] = append(byOrigin[host], station)
+	}
+
+	jobs := make(chan RadioStation)
+	var feeders sync.WaitGroup
+	for _, queue := range byOrigin {
+		feeders.Add(1)
+		go func(queue []RadioStation) {
+			defer feeders.Done()
+			for i, station := range queue {
+				if i > 0 && p.jitter > 0 {
+					select {
+					case <-time.After(time.Duration(rand.Int63n(int64(p.jitter)))):
+					case <-ctx.Done():
+						return
+					}
+				}
+				select {
+				case jobs <- station:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(queue)
+	}
+	go func() {
+		feeders.Wait()
+		close(jobs)
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for station := range jobs {
+				p.record(station, p.probe(ctx, station.URL))
+			}
+		}()
+	}
+	workers.Wait()
+
+	probeSweepDuration.Set(time.Since(start).Seconds())
+}
+
+func (p *availabilityProber) probe(ctx context.Context, streamURL string) bool {
+	streamURL, err := absoluteStationURL(p.baseURL, streamURL)
+	if err != nil {
+		return false
+	}
+	status, err := p.request(ctx, http.MethodHead, streamURL)
+	// Plenty of Icecast/Shoutcast servers reject HEAD, so retry with a GET
+	// and hang up as soon as the headers arrive
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented || status == http.StatusBadRequest) {
+		status, err = p.request(ctx, http.MethodGet, streamURL)
+	}
+	return err == nil && status < 400
+}
+
+func (p *availabilityProber) request(ctx context.Context, method, streamURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, streamURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (p *availabilityProber) record(station RadioStation, available bool) {
+	if available {
+		stationUptimes.markUp(station)
+		p.cooldowns.success(station)
+	} else {
+		stationUptimes.markDown(station)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results[station.stateKey()] = stationProbe{Available: available, CheckedAt: time.Now()}
+}
+
+func (p *availabilityProber) lookup(station RadioStation) (stationProbe, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	result, ok := p.results[station.stateKey()]
+	return result, ok
+}
+
+// Serve the latest probe result for every catalog station; stations not
+// probed yet report up as null
+func stationHealthHandler(api *stationsAPI, logger *log.Logger, prober *availabilityProber) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if prober == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Station health checks are disabled"})
+			return
+		}
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+
+		report := make([]gin.H, 0, len(stations))
+		for _, station := range stations {
+			entry := gin.H{"station": station.Name, "id": station.ID, "up": nil, "last_checked": nil}
+			if result, ok := prober.lookup(station); ok {
+				entry["up"] = result.Available
+				entry["last_checked"] = result.CheckedAt
+			}
+			report = append(report, entry)
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"low":     qrcode.Low,
+	"medium":  qrcode.Medium,
+	"high":    qrcode.High,
+	"highest": qrcode.Highest,
+}
+
+// Scheme and host the client used to reach us, for building absolute links
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
+
+// Serve /qr/:station.png and /qr/id/:id.png, a QR code linking to the
+// station's M3U playlist, which players open more readily than a raw stream
+func qrCodeHandler(config Config, logger *log.Logger, api *stationsAPI) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		file, byID := c.Param("file"), c.Param("id") != ""
+		if byID {
+			file = c.Param("id")
+		}
+		if !strings.HasSuffix(file, ".png") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+		stationName := strings.TrimSuffix(file, ".png")
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		station, found := api.findPathStation(c, stations, stationName, byID)
+		if !found {
+			return
+		}
+
+		target := requestBaseURL(c) + "/playlist/" + stationPathSegment(station) + ".m3u"
+		png, err := qrcode.Encode(target, qrLevels[config.QRLevel], config.QRSize)
+		if err != nil {
+			logger.Printf("Error generating QR code for %s: %v", station.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+			return
+		}
+
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Data(http.StatusOK, "image/png", png)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Outcome of the most recent catalog load, for readiness
+type catalogStatus struct {
+	mu          sync.Mutex
+	checkedAt   time.Time
+	lastSuccess time.Time
+	lastErr     error
+	stations    int
+}
+
+func (s *catalogStatus) record(stations int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkedAt = time.Now()
+	s.lastErr = err
+	if err == nil {
+		s.lastSuccess = s.checkedAt
+		s.stations = stations
+	}
+}
+
+// Ready when the last catalog load succeeded. Probes never wait on the
+// upstream: a stale status only kicks off a background refresh, which the
+// cache collapses to at most one per TTL.
+func readyHandler(api *stationsAPI) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := api.status
+		s.mu.Lock()
+		checkedAt, lastSuccess, lastErr, stations := s.checkedAt, s.lastSuccess, s.lastErr, s.stations
+		s.mu.Unlock()
+
+		if api.cache.ttl > 0 && time.Since(checkedAt) >= api.cache.ttl {
+			go api.fetch(context.Background())
+		}
+
+		catalog := gin.H{"stations": stations}
+		if !checkedAt.IsZero() {
+			catalog["checked_at"] = checkedAt
+		}
+		if !lastSuccess.IsZero() {
+			catalog["last_success"] = lastSuccess
+		}
+		if lastErr != nil {
+			catalog["last_error"] = lastErr.Error()
+		}
+
+		switch {
+		case shuttingDown.Load():
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down", "catalog": catalog})
+		case checkedAt.IsZero():
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting", "catalog": catalog})
+		case lastErr != nil:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "catalog unreachable", "catalog": catalog})
+		default:
+			c.JSON(http.StatusOK, gin.H{"status": "ready", "catalog": catalog})
+		}
+	}
+}
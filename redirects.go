@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var learnedRedirects = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "radio_learned_redirects_total",
+	Help: "Permanent upstream redirects stored as station URL overrides",
+})
+
+// Remembers where stations have permanently moved (301) by storing the
+// target as a URL override, so later listeners skip the redirect. Each
+// station is updated at most max times to stop two origins bouncing
+// listeners back and forth from rewriting it forever.
+type redirectLearner struct {
+	enabled   bool
+	max       int
+	overrides *overrideStore
+	logger    *log.Logger
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newRedirectLearner(config Config, logger *log.Logger, overrides *overrideStore) *redirectLearner {
+	return &redirectLearner{
+		enabled:   config.LearnRedirects,
+		max:       config.MaxLearnedRedirects,
+		overrides: overrides,
+		logger:    logger,
+		counts:    make(map[string]int),
+	}
+}
+
+// A copy of base that notes the final target of an unbroken chain of 301s
+func (l *redirectLearner) client(base *http.Client, moved *string) *http.Client {
+	client := *base
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := checkStationRedirect(req, via); err != nil {
+			return err
+		}
+		if req.Response != nil && req.Response.StatusCode == http.StatusMovedPermanently && (len(via) == 1 || *moved != "") {
+			*moved = req.URL.String()
+		} else {
+			*moved = ""
+		}
+		return nil
+	}
+	return &client
+}
+
+func (l *redirectLearner) learn(station RadioStation, target string) {
+	if !l.enabled || target == "" || target == station.URL {
+		return
+	}
+
+	l.mu.Lock()
+	if l.counts[station.stateKey()] >= l.max {
+		l.mu.Unlock()
+		return
+	}
+	l.counts[station.stateKey()]++
+	n := l.counts[station.stateKey()]
+	l.mu.Unlock()
+
+	l.overrides.set(station.Name, station.ID, target)
+	learnedRedirects.Inc()
+	l.logger.Printf("Station %s moved permanently to %s; using it as an override (%d/%d)", station.Name, target, n, l.max)
+}
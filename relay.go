@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	relayUpstreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "radio_relay_upstream_connections",
+		Help: "Upstream connections held open by the relay (compare with radio_active_streams for fan-out)",
+	})
+	relayDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "radio_relay_dropped_clients_total",
+		Help: "Relay listeners disconnected for falling behind the shared feed",
+	})
+)
+
+// Chunks a listener may fall behind by before it is dropped
+const relaySubscriberQueue = 64
+
+// Shares one upstream connection per station among all of its listeners.
+// The first listener opens the feed; it closes RelayLinger after the last
+// one leaves. Listeners too slow to keep up are dropped so they never
+// stall the shared reader.
+type relayHub struct {
+	config     Config
+	logger     *log.Logger
+	origins    *originLimiter
+	userAgents *userAgentPool
+	cooldowns  *cooldownTracker
+	// Reads ICY titles from feeds when set
+	titles *titleTracker
+	// Meters each feed's audio once for all of its listeners when set
+	levels *levelMeter
+
+	mu    sync.Mutex
+	feeds map[string]*relayFeed
+}
+
+type relayFeed struct {
+	station string
+	ready   chan struct{}
+	err     error
+
+	contentType string
+	icy         map[string]string
+	metaint     int // upstream ICY metadata interval, 0 if none
+	cancel      context.CancelFunc
+	started     time.Time
+	lingerFor   time.Duration
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+	backlog     []byte
+	relayed     int64
+	linger      *time.Timer
+	title       string
+	failed      bool // the upstream ended with an error rather than EOF
+}
+
+// Latest ICY title seen on the feed
+func (f *relayFeed) currentTitle() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.title
+}
+
+func newRelayHub(config Config, logger *log.Logger, origins *originLimiter, userAgents *userAgentPool, cooldowns *cooldownTracker, titles *titleTracker, levels *levelMeter) *relayHub {
+	return &relayHub{
+		config:     config,
+		logger:     logger,
+		origins:    origins,
+		userAgents: userAgents,
+		cooldowns:  cooldowns,
+		titles:     titles,
+		levels:     levels,
+		feeds:      make(map[string]*relayFeed),
+	}
+}
+
+// Subscribe to a station's feed, opening it if needed. The returned
+// backlog is recent audio to send first so playback starts immediately.
+func (h *relayHub) join(ctx context.Context, station RadioStation) (*relayFeed, chan []byte, []byte, error) {
+	h.mu.Lock()
+	feed, ok := h.feeds[station.stateKey()]
+	if !ok {
+		feedCtx, cancel := context.WithCancel(context.Background())
+		feed = &relayFeed{
+			station:     station.Name,
+			ready:       make(chan struct{}),
+			cancel:      cancel,
+			lingerFor:   h.config.RelayLinger,
+			subscribers: make(map[chan []byte]struct{}),
+		}
+		h.feeds[station.stateKey()] = feed
+		go h.run(feedCtx, station, feed)
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-feed.ready:
+	case <-ctx.Done():
+		return nil, nil, nil, ctx.Err()
+	}
+	if feed.err != nil {
+		return nil, nil, nil, feed.err
+	}
+
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if feed.subscribers == nil {
+		return nil, nil, nil, fmt.Errorf("relay feed for %s has ended", station.Name)
+	}
+	if feed.linger != nil {
+		feed.linger.Stop()
+		feed.linger = nil
+	}
+	ch := make(chan []byte, relaySubscriberQueue)
+	feed.subscribers[ch] = struct{}{}
+	return feed, ch, append([]byte(nil), feed.backlog...), nil
+}
+
+// Unsubscribe; the last listener out starts the linger countdown
+func (h *relayHub) leave(feed *relayFeed, ch chan []byte) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if _, ok := feed.subscribers[ch]; !ok {
+		return
+	}
+	delete(feed.subscribers, ch)
+	feed.startLinger()
+}
+
+// Caller must hold feed.mu
+func (f *relayFeed) startLinger() {
+	if len(f.subscribers) == 0 && f.linger == nil {
+		f.linger = time.AfterFunc(f.lingerFor, f.cancel)
+	}
+}
+
+func (h *relayHub) run(ctx context.Context, station RadioStation, feed *relayFeed) {
+	defer func() {
+		h.mu.Lock()
+		if h.feeds[station.stateKey()] == feed {
+			delete(h.feeds, station.stateKey())
+		}
+		h.mu.Unlock()
+		feed.cancel()
+
+		feed.mu.Lock()
+		for ch := range feed.subscribers {
+			close(ch)
+		}
+		feed.subscribers = nil
+		feed.mu.Unlock()
+	}()
+
+	resp, release, err := dialUpstream(ctx, h.config, h.origins, h.userAgents, station.URL, true, nil)
+	if err != nil {
+		feed.err = err
+		close(feed.ready)
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	relayUpstreams.Inc()
+	defer relayUpstreams.Dec()
+	// Drop an upstream that goes silent without closing the connection
+	watchdog := newStreamWatchdog(h.config.StreamIdleTimeout, feed.cancel)
+	defer watchdog.stop()
+
+	// Peeked bytes stay buffered, so sniffing loses no audio
+	upstream := bufio.NewReaderSize(&idleReader{r: resp.Body, wd: watchdog, idle: h.config.StreamIdleTimeout}, h.config.UpstreamReadBuffer)
+	if h.config.RejectHTML && isHTMLResponse(resp, upstream) {
+		feed.err = errHTMLResponse
+		close(feed.ready)
+		return
+	}
+	feed.contentType = getContentType(resp, peekHead(upstream))
+	feed.icy = icyHeaders(resp)
+	feed.metaint = icyMetaint(resp)
+	feed.started = time.Now()
+	close(feed.ready)
+	h.logger.Printf("Relay feed opened for %s", station.Name)
+
+	// The feed carries plain audio; listeners that want metadata get it
+	// re-inserted at their own offsets
+	var body io.Reader = upstream
+	if feed.metaint > 0 {
+		body = newICYStripper(upstream, feed.metaint, func(meta string) {
+			title, ok := parseStreamTitle(meta)
+			if !ok {
+				return
+			}
+			feed.mu.Lock()
+			feed.title = title
+			feed.mu.Unlock()
+			if h.titles != nil {
+				h.titles.observe(station, title)
+			}
+		})
+	}
+	if h.levels != nil {
+		if tap, ok := h.levels.attach(station.Name); ok {
+			defer tap.Close()
+			body = io.TeeReader(body, tap)
+		}
+	}
+
+	buf := make([]byte, h.config.UpstreamReadBuffer)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			feed.broadcast(append([]byte(nil), buf[:n]...), h.config.RelayBacklog)
+		}
+		if err != nil {
+			feed.mu.Lock()
+			feed.failed = err != io.EOF
+			feed.mu.Unlock()
+			if watchdog.expired() {
+				streamErrors.Inc()
+				h.logger.Printf("Relay feed for %s stalled for %s, closing", station.Name, h.config.StreamIdleTimeout)
+			} else if ctx.Err() == nil {
+				h.logger.Printf("Relay feed for %s ended: %v", station.Name, err)
+			} else {
+				h.logger.Printf("Relay feed for %s closed", station.Name)
+			}
+			return
+		}
+	}
+}
+
+// Open a plain audio connection to a stream origin, holding an origin slot
+// until release is called. Error statuses are returned as errors. Allowed
+// headers are copied from the client request when one is given.
+func dialUpstream(ctx context.Context, config Config, origins *originLimiter, userAgents *userAgentPool, streamURL string, icyMeta bool, client *http.Request) (*http.Response, func(), error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", streamURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", userAgents.pick(req.URL.Host))
+	// Shared relay feeds have no single client to take headers from
+	if client != nil {
+		forwardClientHeaders(config, client, req)
+	}
+	if icyMeta {
+		req.Header.Set("Icy-MetaData", "1")
+	}
+
+	if err := waitConnectJitter(ctx, config); err != nil {
+		return nil, nil, err
+	}
+	host := req.URL.Host
+	if err := origins.acquire(ctx, host); err != nil {
+		return nil, nil, err
+	}
+	resp, err := config.StreamClient.Do(req)
+	if err != nil {
+		origins.release(host)
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		origins.release(host)
+		return nil, nil, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+	return resp, func() { origins.release(host) }, nil
+}
+
+// Fan a chunk out without blocking; a full queue means a slow listener
+func (f *relayFeed) broadcast(chunk []byte, backlogSize int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.relayed += int64(len(chunk))
+	f.backlog = append(f.backlog, chunk...)
+	if over := len(f.backlog) - backlogSize; over > 0 {
+		f.backlog = append(f.backlog[:0], f.backlog[over:]...)
+	}
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- chunk:
+		default:
+			delete(f.subscribers, ch)
+			close(ch)
+			relayDropped.Inc()
+		}
+	}
+	f.startLinger()
+}
+
+// Stream a station to the client from its shared feed until ctx ends
+func (h *relayHub) serve(ctx context.Context, c *gin.Context, station RadioStation, forcedType string) {
+	feed, ch, backlog, err := h.join(ctx, station)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		var limitErr *limitError
+		if errors.As(err, &limitErr) {
+			respondLimit(c, limitErr)
+			return
+		}
+		if isStationURLError(err) {
+			respondStationURLError(c, h.logger, station, err)
+			return
+		}
+		streamErrors.Inc()
+		stationUptimes.markDown(station)
+		h.cooldowns.failure(station)
+		if errors.Is(err, errHTMLResponse) {
+			htmlResponses.Inc()
+			h.logger.Printf("Upstream for %s returned an HTML page instead of audio", station.Name)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream returned an HTML page instead of audio"})
+			return
+		}
+		h.logger.Printf("Relay for %s unavailable: %v", station.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to stream"})
+		return
+	}
+	defer h.leave(feed, ch)
+
+	contentType := servedContentType(h.logger, station.Name, feed.contentType, forcedType)
+	c.Header("Content-Type", contentType)
+	copyICYInfo(c, func(name string) string { return feed.icy[name] })
+	c.Header("Transfer-Encoding", "chunked")
+	if c.Query("download") == "1" {
+		c.Header("Content-Disposition", downloadDisposition(station.Name, contentType))
+	}
+	var out io.Writer = c.Writer
+	if feed.metaint > 0 && c.GetHeader("Icy-MetaData") == "1" {
+		c.Header("icy-metaint", strconv.Itoa(feed.metaint))
+		out = newICYInjector(c.Writer, feed.metaint, feed.currentTitle)
+	}
+	if h.config.StreamConnection != "" && c.Request.ProtoMajor == 1 {
+		c.Header("Connection", h.config.StreamConnection)
+	}
+	c.Status(http.StatusOK)
+
+	streamCodecs.WithLabelValues(codecLabel(contentType)).Inc()
+	activeStreams.Inc()
+	defer activeStreams.Dec()
+	stationUptimes.markUp(station)
+	h.cooldowns.success(station)
+	stationUptimes.streamStarted(station)
+	defer stationUptimes.streamEnded(station)
+
+	started := time.Now()
+	var written int64
+	defer func() { recordStreamEnd(c, station.Name, station.URL, written, started) }()
+
+	if len(backlog) > 0 {
+		n, err := out.Write(backlog)
+		written += int64(n)
+		if err != nil {
+			streamClientDisconnects.WithLabelValues(station.Name).Inc()
+			return
+		}
+		c.Writer.Flush()
+		recordStreamTTFB(c, station.Name)
+	}
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				h.recordFeedEnd(feed, station.Name)
+				return
+			}
+			first := written == 0
+			n, err := out.Write(chunk)
+			written += int64(n)
+			if err != nil {
+				streamClientDisconnects.WithLabelValues(station.Name).Inc()
+				return
+			}
+			c.Writer.Flush()
+			if first {
+				recordStreamTTFB(c, station.Name)
+			}
+		case <-ctx.Done():
+			streamClientDisconnects.WithLabelValues(station.Name).Inc()
+			return
+		}
+	}
+}
+
+// Count why a listener's channel was closed: the feed ending, cleanly or
+// not, or the listener being dropped for falling behind
+func (h *relayHub) recordFeedEnd(feed *relayFeed, station string) {
+	feed.mu.Lock()
+	ended, failed := feed.subscribers == nil, feed.failed
+	feed.mu.Unlock()
+	switch {
+	case !ended:
+		streamClientDisconnects.WithLabelValues(station).Inc()
+	case failed:
+		streamUpstreamErrors.WithLabelValues(station).Inc()
+	default:
+		streamCompletions.WithLabelValues(station).Inc()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRelayedStreamsMatchDirectHeaders(t *testing.T) {
+	upstream := endlessUpstream(t)
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Jazz FM", URL: upstream.URL + "/live"})
+
+	for _, relay := range []bool{false, true} {
+		config := testConfig(catalog.URL)
+		config.Relay = relay
+		config.RelayLinger = 10 * time.Millisecond
+		srv := streamTestServer(t, config)
+
+		before := collectorValues(streamCodecs)["mp3"]
+		resp, err := http.Get(srv.URL + "/stream/Jazz%20FM?download=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("relay=%t: status = %d", relay, resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("Content-Disposition"), `attachment; filename=Jazz_FM.mp3`; got != want {
+			t.Errorf("relay=%t: Content-Disposition = %q, want %q", relay, got, want)
+		}
+		if got := collectorValues(streamCodecs)["mp3"] - before; got != 1 {
+			t.Errorf("relay=%t: mp3 stream starts grew by %v, want 1", relay, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Point-in-time state of one relayed feed, for GET /admin/relays
+type relayStatus struct {
+	Station      string  `json:"station"`
+	State        string  `json:"state"`
+	Subscribers  int     `json:"subscribers"`
+	BufferFill   float64 `json:"buffer_fill"`
+	BytesRelayed int64   `json:"bytes_relayed"`
+	AgeSeconds   float64 `json:"age_seconds"`
+}
+
+func (h *relayHub) snapshot() []relayStatus {
+	h.mu.Lock()
+	feeds := make([]*relayFeed, 0, len(h.feeds))
+	for _, feed := range h.feeds {
+		feeds = append(feeds, feed)
+	}
+	h.mu.Unlock()
+
+	statuses := make([]relayStatus, 0, len(feeds))
+	for _, feed := range feeds {
+		status := relayStatus{Station: feed.station, State: "connecting"}
+		select {
+		case <-feed.ready:
+			status.State = "connected"
+		default:
+		}
+		feed.mu.Lock()
+		if status.State == "connected" {
+			status.Subscribers = len(feed.subscribers)
+			if h.config.RelayBacklog > 0 {
+				status.BufferFill = float64(len(feed.backlog)) / float64(h.config.RelayBacklog)
+			}
+			status.BytesRelayed = feed.relayed
+			status.AgeSeconds = time.Since(feed.started).Seconds()
+			if feed.linger != nil {
+				status.State = "lingering"
+			}
+		}
+		feed.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Station < statuses[j].Station })
+	return statuses
+}
+
+func relaysHandler(relay *relayHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if relay == nil {
+			c.JSON(http.StatusOK, []relayStatus{})
+			return
+		}
+		c.JSON(http.StatusOK, relay.snapshot())
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Config as currently in force. Request handlers that honor reloaded
+// settings read it per request; the snapshot must not be modified.
+type liveConfig struct {
+	current atomic.Pointer[Config]
+}
+
+func newLiveConfig(config Config) *liveConfig {
+	l := &liveConfig{}
+	l.current.Store(&config)
+	return l
+}
+
+func (l *liveConfig) get() *Config {
+	return l.current.Load()
+}
+
+// Settings a SIGHUP applies from the -config file without a restart: the
+// log level, the stream limits, the stream address lists and CORS.
+var hotSettings = []struct {
+	name, env string
+	set       func(config *Config, nets *addressNets, val string) error
+}{
+	{"log-level", "RADIO_LOG_LEVEL", func(config *Config, _ *addressNets, val string) error {
+		return config.LogLevel.UnmarshalText([]byte(val))
+	}},
+	{"max-active-streams", "RADIO_MAX_ACTIVE_STREAMS", func(config *Config, _ *addressNets, val string) error {
+		return setLimit(&config.MaxActiveStreams, val)
+	}},
+	{"max-streams-per-ip", "RADIO_MAX_STREAMS_PER_IP", func(config *Config, _ *addressNets, val string) error {
+		return setLimit(&config.MaxStreamsPerIP, val)
+	}},
+	{"stream-rate-per-ip", "RADIO_STREAM_RATE_PER_IP", func(config *Config, _ *addressNets, val string) error {
+		return setLimit(&config.StreamRatePerIP, val)
+	}},
+	{"stream-allow-cidrs", "RADIO_STREAM_ALLOW_CIDRS", func(_ *Config, nets *addressNets, val string) (err error) {
+		nets.allow, err = parseCIDRs(splitList(val))
+		return err
+	}},
+	{"stream-deny-cidrs", "RADIO_STREAM_DENY_CIDRS", func(_ *Config, nets *addressNets, val string) (err error) {
+		nets.deny, err = parseCIDRs(splitList(val))
+		return err
+	}},
+	{"cors-origins", "RADIO_CORS_ORIGINS", func(config *Config, _ *addressNets, val string) error {
+		config.CORSOrigins = splitList(val)
+		if len(config.CORSOrigins) == 0 {
+			return errors.New("cannot be empty")
+		}
+		return nil
+	}},
+	{"cors-methods", "RADIO_CORS_METHODS", func(config *Config, _ *addressNets, val string) error {
+		config.CORSMethods = splitList(strings.ToUpper(val))
+		if len(config.CORSMethods) == 0 {
+			return errors.New("cannot be empty")
+		}
+		return nil
+	}},
+	{"cors-headers", "RADIO_CORS_HEADERS", func(config *Config, _ *addressNets, val string) error {
+		config.CORSHeaders = splitList(val)
+		return nil
+	}},
+	{"cors-max-age", "RADIO_CORS_MAX_AGE", func(config *Config, _ *addressNets, val string) (err error) {
+		config.CORSMaxAge, err = time.ParseDuration(val)
+		return err
+	}},
+	{"cors-credentials", "RADIO_CORS_CREDENTIALS", func(config *Config, _ *addressNets, val string) (err error) {
+		config.CORSAllowCredentials, err = strconv.ParseBool(val)
+		return err
+	}},
+}
+
+func setLimit(target *int, val string) error {
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return errors.New("cannot be negative")
+	}
+	*target = n
+	return nil
+}
+
+func isHotSetting(name string) bool {
+	for _, s := range hotSettings {
+		if s.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Re-read the -config file and apply its hot settings. Flags and
+// environment variables still win over the file, and a key removed from
+// the file falls back to the flag default. Nothing is applied unless every
+// hot setting is valid. Returns the changed keys that need a restart.
+func reloadConfigFile(live *liveConfig) ([]string, error) {
+	current := live.get()
+	settings, err := loadConfigFile(current.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *current
+	nets := current.StreamAddresses.current()
+	for _, s := range hotSettings {
+		if _, ok := current.ExplicitFlags[s.name]; ok || os.Getenv(s.env) != "" {
+			continue
+		}
+		val, ok := settings[s.name]
+		if !ok {
+			val = flag.Lookup(s.name).DefValue
+		}
+		if err := s.set(&next, &nets, val); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s.name, err)
+		}
+	}
+
+	var restart []string
+	changed := func(key string) {
+		if key == "config" || isHotSetting(key) || flag.Lookup(key) == nil {
+			return
+		}
+		if _, ok := current.ExplicitFlags[key]; ok {
+			return
+		}
+		restart = append(restart, key)
+	}
+	for key, val := range settings {
+		if prev, ok := current.FileSettings[key]; !ok || prev != val {
+			changed(key)
+		}
+	}
+	for key := range current.FileSettings {
+		if _, ok := settings[key]; !ok {
+			changed(key)
+		}
+	}
+	sort.Strings(restart)
+
+	next.FileSettings = settings
+	next.StreamAddresses.set(nets.allow, nets.deny)
+	logLevel.Set(next.LogLevel)
+	live.current.Store(&next)
+	return restart, nil
+}
+
+// Re-read file-based settings on SIGHUP without dropping active streams:
+// the hot settings of the -config file and the whole -sources file. Other
+// settings are fixed for the life of the process; changes to them in the
+// config file are logged as needing a restart.
+func watchReload(live *liveConfig, logger *log.Logger, api *stationsAPI) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		config := live.get()
+		if config.ConfigFile == "" && config.SourcesFile == "" {
+			logger.Printf("SIGHUP received; no config or sources file to reload")
+			continue
+		}
+
+		if config.ConfigFile != "" {
+			restart, err := reloadConfigFile(live)
+			if err != nil {
+				logger.Printf("Reload failed, keeping current settings: %v", err)
+			} else {
+				logger.Printf("Reloaded settings from %s", config.ConfigFile)
+				for _, key := range restart {
+					logger.Printf("Warning: %s changed in %s; restart to apply", key, config.ConfigFile)
+				}
+			}
+		}
+
+		if config.SourcesFile != "" {
+			sources, err := loadStationSources(config.SourcesFile)
+			if err != nil {
+				logger.Printf("Reload failed, keeping current station sources: %v", err)
+				continue
+			}
+			api.setSources(sources)
+			logger.Printf("Reloaded %d station sources from %s", len(sources), config.SourcesFile)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReloadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "radio.json")
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"max-active-streams": 2, "cors-origins": "https://a.example", "log-level": "warn", "cache-ttl": "1m"}`)
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+
+	config := parseTestArgs(t, "-config", path, "-max-streams-per-ip", "3")
+	live := newLiveConfig(config)
+
+	write(`{"max-active-streams": 5, "max-streams-per-ip": 9, "stream-deny-cidrs": "203.0.113.0/24", "cache-ttl": "2m"}`)
+	restart, err := reloadConfigFile(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restart, []string{"cache-ttl"}) {
+		t.Errorf("restart needed for %v, want [cache-ttl]", restart)
+	}
+	got := live.get()
+	if got.MaxActiveStreams != 5 {
+		t.Errorf("max active streams %d, want 5 from the file", got.MaxActiveStreams)
+	}
+	if got.MaxStreamsPerIP != 3 {
+		t.Errorf("max streams per IP %d, want 3 from the command line", got.MaxStreamsPerIP)
+	}
+	if !reflect.DeepEqual(got.CORSOrigins, []string{"*"}) || logLevel.Level() != slog.LevelInfo {
+		t.Errorf("CORS origins %v, log level %s, want the defaults once removed from the file", got.CORSOrigins, logLevel.Level())
+	}
+	if !got.StreamAddresses.blocked(net.ParseIP("203.0.113.7")) {
+		t.Error("reloaded deny list not applied")
+	}
+	if got.CacheTTL != config.CacheTTL {
+		t.Errorf("cache TTL changed to %s without a restart", got.CacheTTL)
+	}
+
+	write(`{"max-active-streams": -1, "cors-origins": "https://b.example"}`)
+	if _, err := reloadConfigFile(live); err == nil {
+		t.Fatal("invalid config file accepted")
+	}
+	if live.get() != got {
+		t.Error("failed reload changed the live config")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest batch POST /stations/resolve accepts
+const maxResolveBatch = 100
+
+type resolvedStation struct {
+	Query     json.RawMessage `json:"query"`
+	Found     bool            `json:"found"`
+	ID        int             `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	StreamURL string          `json:"stream_url,omitempty"`
+	Available *bool           `json:"available,omitempty"`
+}
+
+// Resolve a JSON array of station names (strings) and IDs (numbers) in one
+// round trip, for clients that would otherwise look each one up in turn
+func resolveStationsHandler(api *stationsAPI, logger *log.Logger, prober *availabilityProber) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var queries []json.RawMessage
+		if err := c.ShouldBindJSON(&queries); err != nil {
+			if respondBodyTooLarge(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a JSON array of station names or IDs"})
+			return
+		}
+		if len(queries) > maxResolveBatch {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Too many stations in one request", "max": maxResolveBatch})
+			return
+		}
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+
+		base := requestBaseURL(c)
+		results := make([]resolvedStation, 0, len(queries))
+		for _, query := range queries {
+			result := resolvedStation{Query: query}
+			if station, ok := api.resolveQuery(stations, query); ok {
+				result.Found = true
+				result.ID = station.ID
+				result.Name = station.Name
+				result.StreamURL = base + "/stream/" + stationPathSegment(station)
+				if prober != nil {
+					if probe, ok := prober.lookup(station); ok {
+						result.Available = &probe.Available
+					}
+				}
+			}
+			results = append(results, result)
+		}
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// Match a query by name if it's a string, by ID if it's a number
+func (a *stationsAPI) resolveQuery(stations []RadioStation, query json.RawMessage) (RadioStation, bool) {
+	var name string
+	if err := json.Unmarshal(query, &name); err == nil {
+		return a.findStation(stations, name)
+	}
+	var id int
+	if err := json.Unmarshal(query, &id); err == nil {
+		return findStationByID(stations, id)
+	}
+	return RadioStation{}, false
+}
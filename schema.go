@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Maps upstream catalog JSON keys onto RadioStation fields, for APIs that
+// don't use id/name/url
+type fieldMap struct {
+	ID        string
+	Name      string
+	URL       string
+	Logo      string
+	CreatedAt string
+	Genre     string
+}
+
+var defaultFieldMap = fieldMap{ID: "id", Name: "name", URL: "url", Logo: "logo_url", CreatedAt: "created_at", Genre: "genre"}
+
+// Parse a spec like "id=station_id,name=title,url=stream"; fields left
+// out keep their default key
+func parseFieldMap(spec string) (fieldMap, error) {
+	m := defaultFieldMap
+	for _, pair := range splitList(spec) {
+		field, key, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return m, fmt.Errorf("invalid field mapping %q", pair)
+		}
+		switch strings.TrimSpace(field) {
+		case "id":
+			m.ID = key
+		case "name":
+			m.Name = key
+		case "url":
+			m.URL = key
+		case "logo":
+			m.Logo = key
+		case "created_at":
+			m.CreatedAt = key
+		case "genre":
+			m.Genre = key
+		default:
+			return m, fmt.Errorf("unknown station field %q", field)
+		}
+	}
+	return m, nil
+}
+
+func (m fieldMap) String() string {
+	return fmt.Sprintf("id=%s,name=%s,url=%s,logo=%s,created_at=%s,genre=%s", m.ID, m.Name, m.URL, m.Logo, m.CreatedAt, m.Genre)
+}
+
+// Decode a catalog with this mapping. Entries without a name or URL are
+// skipped; if no entry has both, the payload is taken to be in some other
+// shape and decoding fails.
+func (m fieldMap) decode(data []byte) ([]RadioStation, error) {
+	var entries []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	stations := make([]RadioStation, 0, len(entries))
+	for i, entry := range entries {
+		var station RadioStation
+		if json.Unmarshal(entry[m.Name], &station.Name) != nil || json.Unmarshal(entry[m.URL], &station.URL) != nil {
+			continue
+		}
+		if station.Name == "" || station.URL == "" {
+			continue
+		}
+		if raw, ok := entry[m.ID]; ok {
+			id, err := decodeID(raw)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: invalid %q: %v", i, m.ID, err)
+			}
+			station.ID = id
+		}
+		// Optional; values of the wrong shape are ignored rather than
+		// failing the entry
+		if raw, ok := entry[m.Logo]; ok {
+			json.Unmarshal(raw, &station.LogoURL)
+		}
+		if raw, ok := entry[m.CreatedAt]; ok {
+			json.Unmarshal(raw, &station.CreatedAt)
+		}
+		if raw, ok := entry[m.Genre]; ok {
+			json.Unmarshal(raw, &station.Genre)
+		}
+		stations = append(stations, station)
+	}
+
+	if len(entries) > 0 && len(stations) == 0 {
+		return nil, fmt.Errorf("no entries have both %q and %q", m.Name, m.URL)
+	}
+	return stations, nil
+}
+
+// IDs arrive as numbers from most APIs, as numeric strings from some
+func decodeID(raw json.RawMessage) (int, error) {
+	var id int
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
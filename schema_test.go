@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeFallsBackToSecondarySchema(t *testing.T) {
+	primary, err := parseFieldMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback, err := parseFieldMap("id=station_id,name=title,url=stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig("http://catalog.example/")
+	config.Schemas = []fieldMap{primary, fallback}
+	api := newStationsAPI(config, testLogger)
+	src := stationSource{URL: "http://catalog.example/"}
+
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"primary", `[{"id": 1, "name": "Jazz", "url": "http://jazz.example/"}]`, "Jazz"},
+		{"fallback", `[{"station_id": "7", "title": "Rock", "stream": "http://rock.example/"}]`, "Rock"},
+		{"neither", `[{"label": "Folk", "href": "http://folk.example/"}]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stations, err := api.decode(src, []byte(tt.payload))
+			if tt.want == "" {
+				if !errors.Is(err, errParseCatalog) {
+					t.Fatalf("err = %v, want errParseCatalog", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stations) != 1 || stations[0].Name != tt.want {
+				t.Errorf("decoded %+v, want one station named %s", stations, tt.want)
+			}
+		})
+	}
+}
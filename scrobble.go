@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type titleEvent struct {
+	Station   string    `json:"station"`
+	Title     string    `json:"title"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Watches ICY titles seen by relayed feeds, posting each change to a
+// webhook and keeping the last few per station. A change counts once the
+// title has held for the debounce interval, so stations flapping between
+// titles record one event.
+type titleTracker struct {
+	logger      *log.Logger
+	webhook     string
+	debounce    time.Duration
+	historySize int
+	client      *http.Client
+
+	mu       sync.Mutex
+	stations map[string]*stationTitle
+}
+
+type stationTitle struct {
+	reported string
+	pending  string
+	timer    *time.Timer
+	// Newest first, at most historySize entries
+	history  []titleEvent
+	lastSeen time.Time
+}
+
+func newTitleTracker(config Config, logger *log.Logger) *titleTracker {
+	t := &titleTracker{
+		logger:      logger,
+		webhook:     config.ScrobbleWebhook,
+		debounce:    config.ScrobbleDebounce,
+		historySize: config.HistorySize,
+		client:      &http.Client{Transport: config.StreamClient.Transport, Timeout: 10 * time.Second},
+		stations:    make(map[string]*stationTitle),
+	}
+	if config.HistorySize > 0 {
+		go t.evictIdle(config.HistoryIdle)
+	}
+	return t
+}
+
+// Record a title read from a station's stream. Never blocks on the webhook.
+func (t *titleTracker) observe(station RadioStation, title string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stations[station.stateKey()]
+	if !ok {
+		s = &stationTitle{}
+		t.stations[station.stateKey()] = s
+	}
+	s.lastSeen = time.Now()
+	if title == s.pending {
+		return
+	}
+	s.pending = title
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(t.debounce, func() { t.settle(station.Name, s) })
+}
+
+func (t *titleTracker) settle(station string, s *stationTitle) {
+	t.mu.Lock()
+	title := s.pending
+	changed := title != s.reported && title != ""
+	s.reported = title
+	event := titleEvent{Station: station, Title: title, Timestamp: time.Now()}
+	if changed && t.historySize > 0 {
+		s.history = append([]titleEvent{event}, s.history[:min(len(s.history), t.historySize-1)]...)
+	}
+	t.mu.Unlock()
+
+	if changed && t.webhook != "" {
+		go t.post(event)
+	}
+}
+
+// Recent titles for a station, current title first
+func (t *titleTracker) history(station RadioStation) []titleEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stations[station.stateKey()]
+	if !ok {
+		return []titleEvent{}
+	}
+	s.lastSeen = time.Now()
+	return append([]titleEvent{}, s.history...)
+}
+
+// Forget stations that have been neither relayed nor asked about for idle
+func (t *titleTracker) evictIdle(idle time.Duration) {
+	ticker := time.NewTicker(idle / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		t.mu.Lock()
+		for station, s := range t.stations {
+			if time.Since(s.lastSeen) >= idle {
+				if s.timer != nil {
+					s.timer.Stop()
+				}
+				delete(t.stations, station)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Serve a station's recent titles as a JSON array, newest first
+func historyHandler(logger *log.Logger, api *stationsAPI, titles *titleTracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stationName := c.Param("station")
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		station, found := api.findStation(stations, stationName)
+		if !found {
+			api.respondStationNotFound(c, stations, stationName)
+			return
+		}
+		c.JSON(http.StatusOK, titles.history(station))
+	}
+}
+
+func (t *titleTracker) post(event titleEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	resp, err := t.client.Post(t.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.logger.Printf("Scrobble webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.logger.Printf("Scrobble webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// Report whether a station is tagged with genre. Genres are matched
+// case-insensitively against the station's comma-separated list.
+func hasGenre(station RadioStation, genre string) bool {
+	for _, g := range strings.Split(station.Genre, ",") {
+		if strings.EqualFold(strings.TrimSpace(g), genre) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter the catalog by name substring and genre, paginated and sorted by
+// name. The genre filter is ignored when no station carries genre data.
+func searchStationsHandler(api *stationsAPI, logger *log.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, offset := defaultSearchLimit, 0
+		if val := c.Query("limit"); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxSearchLimit)})
+				return
+			}
+			limit = n
+		}
+		if val := c.Query("offset"); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+				return
+			}
+			offset = n
+		}
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+
+		query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+		genre := strings.TrimSpace(c.Query("genre"))
+		if genre != "" {
+			known := false
+			for _, station := range stations {
+				if station.Genre != "" {
+					known = true
+					break
+				}
+			}
+			if !known {
+				genre = ""
+			}
+		}
+
+		matches := make([]RadioStation, 0)
+		for _, station := range stations {
+			if query != "" && !strings.Contains(strings.ToLower(station.Name), query) {
+				continue
+			}
+			if genre != "" && !hasGenre(station, genre) {
+				continue
+			}
+			matches = append(matches, station)
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			a, b := strings.ToLower(matches[i].Name), strings.ToLower(matches[j].Name)
+			if a != b {
+				return a < b
+			}
+			return matches[i].ID < matches[j].ID
+		})
+
+		total := len(matches)
+		page := matches[min(offset, total):min(offset+limit, total)]
+		c.JSON(http.StatusOK, gin.H{
+			"total":    total,
+			"limit":    limit,
+			"offset":   offset,
+			"stations": page,
+		})
+	}
+}
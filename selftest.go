@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxSelftestListeners = 1000
+	maxSelftestDuration  = 5 * time.Minute
+)
+
+// Selftest listeners send "<token>:<n>" in this header. The token is
+// random per process and only honored over loopback, so outside clients
+// cannot claim a selftest identity.
+const selftestHeader = "X-Radio-Selftest"
+
+var selftestToken = newRequestID() + newRequestID()
+
+// The selftest listener a request comes from, if any. Each one counts as
+// its own client, so duplicate-stream handling and per-client limits
+// leave them be.
+func selftestListener(c *gin.Context) (string, bool) {
+	token, id, ok := strings.Cut(c.GetHeader(selftestHeader), ":")
+	if !ok || !isLoopbackRequest(c.Request) || subtle.ConstantTimeCompare([]byte(token), []byte(selftestToken)) != 1 {
+		return "", false
+	}
+	return id, true
+}
+
+type selftestRequest struct {
+	Station   string `json:"station"`
+	Listeners int    `json:"listeners"`
+	Duration  string `json:"duration"`
+}
+
+// Load test driven through the real /stream handler: N listeners connect
+// back to this instance over loopback, read for the duration, then hang up.
+// Only one runs at a time.
+func selftestHandler(config Config, logger *log.Logger) gin.HandlerFunc {
+	var running atomic.Bool
+
+	return func(c *gin.Context) {
+		var req selftestRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			if respondBodyTooLarge(c, err) {
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid selftest request"})
+			return
+		}
+		if req.Station == "" {
+			req.Station = config.VirtualStation
+		}
+		if req.Station == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "station is required"})
+			return
+		}
+		if req.Listeners < 1 || req.Listeners > maxSelftestListeners {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("listeners must be between 1 and %d", maxSelftestListeners)})
+			return
+		}
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 || duration > maxSelftestDuration {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("duration must be between 0 and %s", maxSelftestDuration)})
+			return
+		}
+
+		if !running.CompareAndSwap(false, true) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A selftest is already running"})
+			return
+		}
+		defer running.Store(false)
+
+		logger.Printf("Selftest: %d listeners on %s for %s", req.Listeners, req.Station, duration)
+		c.JSON(http.StatusOK, runSelftest(c.Request.Context(), config, req.Station, req.Listeners, duration))
+	}
+}
+
+func runSelftest(ctx context.Context, config Config, station string, listeners int, duration time.Duration) gin.H {
+	scheme := "http"
+	transport := &http.Transport{MaxIdleConnsPerHost: listeners}
+	if config.EnableHTTPS {
+		scheme = "https"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport}
+	defer transport.CloseIdleConnections()
+	streamURL := fmt.Sprintf("%s://127.0.0.1:%s/stream/%s", scheme, config.Port, url.PathEscape(station))
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		bytesRead atomic.Int64
+		failures  atomic.Int64
+		connected atomic.Int64
+	)
+	start := time.Now()
+	for i := 0; i < listeners; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+			if err != nil {
+				failures.Add(1)
+				return
+			}
+			req.Header.Set(selftestHeader, fmt.Sprintf("%s:%d", selftestToken, i))
+			setAuth(config, req)
+			resp, err := client.Do(req)
+			if err != nil {
+				failures.Add(1)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				failures.Add(1)
+				return
+			}
+			connected.Add(1)
+			n, err := io.Copy(io.Discard, resp.Body)
+			bytesRead.Add(n)
+			if err != nil && ctx.Err() == nil {
+				failures.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return gin.H{
+		"station":          station,
+		"listeners":        listeners,
+		"connected":        connected.Load(),
+		"errors":           failures.Load(),
+		"error_rate":       float64(failures.Load()) / float64(listeners),
+		"bytes":            bytesRead.Load(),
+		"throughput_bps":   float64(bytesRead.Load()*8) / elapsed.Seconds(),
+		"duration_seconds": elapsed.Seconds(),
+		"goroutines":       runtime.NumGoroutine(),
+		"heap_bytes":       mem.HeapAlloc,
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var duplicateStreams = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "radio_duplicate_streams_total",
+		Help: "Second streams of a station from the same client, by action taken",
+	},
+	[]string{"action"},
+)
+
+type streamSession struct {
+	key string
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	replaced bool
+}
+
+// Attach the upstream cancel func; cancels at once if already replaced
+func (s *streamSession) bind(cancel context.CancelFunc) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel = cancel
+	if s.replaced {
+		cancel()
+	}
+}
+
+func (s *streamSession) replace() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaced = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+func (s *streamSession) wasReplaced() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaced
+}
+
+// Tracks one stream per client and station so a reconnecting client can't
+// hold two upstream connections. Mode "reject" refuses the newcomer,
+// "takeover" closes the older stream; empty disables tracking.
+type streamSessions struct {
+	mode string
+
+	mu     sync.Mutex
+	active map[string]*streamSession
+}
+
+func newStreamSessions(config Config) *streamSessions {
+	return &streamSessions{
+		mode:   config.DuplicateStreams,
+		active: make(map[string]*streamSession),
+	}
+}
+
+// Clients are identified by IP. Request headers can't be trusted for
+// this, and the configured credentials are shared by every client. Only
+// selftest listeners get their own identity.
+func sessionKey(c *gin.Context, station RadioStation) string {
+	if id, ok := selftestListener(c); ok {
+		return "selftest-" + id + "|" + station.stateKey()
+	}
+	return c.ClientIP() + "|" + station.stateKey()
+}
+
+// Register a stream; false means it duplicates one that must be kept
+func (s *streamSessions) start(c *gin.Context, station RadioStation) (*streamSession, bool) {
+	if s.mode == "" {
+		return nil, true
+	}
+	key := sessionKey(c, station)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.active[key]; ok {
+		duplicateStreams.WithLabelValues(s.mode).Inc()
+		if s.mode == "reject" {
+			return nil, false
+		}
+		old.replace()
+	}
+	session := &streamSession{key: key}
+	s.active[key] = session
+	return session, true
+}
+
+func (s *streamSessions) end(session *streamSession) {
+	if session == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active[session.key] == session {
+		delete(s.active, session.key)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// An upstream that streams until the listener goes away
+func endlessUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	frame := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 256)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		for r.Context().Err() == nil {
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func openStream(t *testing.T, url, apiKey string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestDuplicateStreamsKeyOnClientIP(t *testing.T) {
+	upstream := endlessUpstream(t)
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Jazz", URL: upstream.URL + "/live"})
+
+	t.Run("reject ignores X-API-Key", func(t *testing.T) {
+		config := testConfig(catalog.URL)
+		config.DuplicateStreams = "reject"
+		srv := streamTestServer(t, config)
+
+		first := openStream(t, srv.URL+"/stream/Jazz", "one")
+		defer first.Body.Close()
+		if first.StatusCode != http.StatusOK {
+			t.Fatalf("first stream: status = %d", first.StatusCode)
+		}
+		second := openStream(t, srv.URL+"/stream/Jazz", "two")
+		second.Body.Close()
+		if second.StatusCode != http.StatusConflict {
+			t.Errorf("second stream with another key: status = %d, want 409", second.StatusCode)
+		}
+	})
+
+	t.Run("takeover closes the older stream", func(t *testing.T) {
+		config := testConfig(catalog.URL)
+		config.DuplicateStreams = "takeover"
+		srv := streamTestServer(t, config)
+
+		first := openStream(t, srv.URL+"/stream/Jazz", "one")
+		defer first.Body.Close()
+		second := openStream(t, srv.URL+"/stream/Jazz", "two")
+		defer second.Body.Close()
+		if second.StatusCode != http.StatusOK {
+			t.Fatalf("second stream: status = %d, want 200", second.StatusCode)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, first.Body)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the older stream was not closed")
+		}
+	})
+}
+
+func TestSelftestListenersAreDistinct(t *testing.T) {
+	upstream := endlessUpstream(t)
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Jazz", URL: upstream.URL + "/live"})
+	config := testConfig(catalog.URL)
+	config.DuplicateStreams = "reject"
+	config.MaxStreamsPerIP = 1
+	srv := streamTestServer(t, config)
+
+	listen := func(token string, i int) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/stream/Jazz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(selftestHeader, fmt.Sprintf("%s:%d", token, i))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// An ordinary listener uses up the per-IP limit and the IP's session
+	first := openStream(t, srv.URL+"/stream/Jazz", "")
+	defer first.Body.Close()
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("first stream: status = %d", first.StatusCode)
+	}
+	for i := 0; i < 3; i++ {
+		resp := listen(selftestToken, i)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("selftest listener %d: status = %d, want 200", i, resp.StatusCode)
+		}
+	}
+	forged := listen("forged", 3)
+	forged.Body.Close()
+	if forged.StatusCode == http.StatusOK {
+		t.Error("a wrong selftest token was honored")
+	}
+}
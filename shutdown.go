@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Set once SIGINT/SIGTERM arrives so /health can tell load balancers to drain
+var shuttingDown atomic.Bool
+
+// Serve until SIGINT/SIGTERM, then stop accepting connections and give
+// active streams the shutdown timeout to finish before closing them
+func runServer(config Config, logger *log.Logger, srv *http.Server) {
+	errChan := make(chan error, 1)
+	go func() {
+		if config.EnableHTTPS {
+			logger.Printf("Starting HTTPS server on port %s...", config.Port)
+			errChan <- srv.ListenAndServeTLS(config.SSLCert, config.SSLKey)
+		} else {
+			logger.Printf("Starting HTTP server on port %s...", config.Port)
+			errChan <- srv.ListenAndServe()
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errChan:
+		logger.Fatal(err)
+	case sig := <-stop:
+		logger.Printf("Received %s, draining for up to %s", sig, config.ShutdownTimeout)
+	}
+
+	shuttingDown.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		// Streams never go idle on their own; cut whatever is left
+		logger.Printf("Grace period over, closing %v active streams", metricValue(activeStreams))
+		srv.Close()
+	}
+	if err := <-errChan; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Printf("Server error during shutdown: %v", err)
+	}
+	logger.Println("Server stopped")
+}
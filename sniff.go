@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// Guess the audio format from the first bytes of a stream, or return ""
+// when the bytes match no known signature
+func sniffAudioType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return "audio/ogg"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		return "audio/wav"
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "audio/mpeg"
+	}
+
+	// Live streams are often joined mid-frame, so look for the first
+	// frame sync rather than requiring it at offset 0
+	for i := 0; i+1 < len(head); i++ {
+		if head[i] != 0xFF || head[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		// ADTS (AAC) uses MPEG layer bits 00, which MPEG audio never does
+		if head[i+1]&0xF6 == 0xF0 {
+			return "audio/aac"
+		}
+		if head[i+1]&0x06 != 0 {
+			return "audio/mpeg"
+		}
+	}
+	return ""
+}
+
+// Bytes already buffered at the front of r, read without consuming them.
+// Waits for at least one byte.
+func peekHead(r *bufio.Reader) []byte {
+	if _, err := r.Peek(1); err != nil {
+		return nil
+	}
+	head, _ := r.Peek(r.Buffered())
+	return head
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSniffAudioType(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"ogg", []byte("OggS\x00\x02"), "audio/ogg"},
+		{"flac", []byte("fLaC\x00\x00\x00\x22"), "audio/flac"},
+		{"wav", []byte("RIFF\x24\x08\x00\x00WAVEfmt "), "audio/wav"},
+		{"riff but not wav", []byte("RIFF\x24\x08\x00\x00AVI LIST"), ""},
+		{"short riff", []byte("RIFF\x24\x08"), ""},
+		{"id3 tag", []byte("ID3\x04\x00\x00"), "audio/mpeg"},
+		{"mpeg frame", []byte{0xFF, 0xFB, 0x90, 0x64}, "audio/mpeg"},
+		{"mpeg joined mid-frame", []byte{0x12, 0x34, 0x56, 0xFF, 0xF3, 0x44}, "audio/mpeg"},
+		{"adts mpeg-4", []byte{0xFF, 0xF1, 0x50, 0x80}, "audio/aac"},
+		{"adts mpeg-2", []byte{0xFF, 0xF9, 0x50, 0x80}, "audio/aac"},
+		{"lone sync byte", []byte{0x00, 0xFF}, ""},
+		{"html", []byte("<!DOCTYPE html>"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffAudioType(tt.head); got != tt.want {
+				t.Errorf("sniffAudioType(% x) = %q, want %q", tt.head, got, tt.want)
+			}
+		})
+	}
+}
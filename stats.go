@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Captured at boot for uptime reporting
+var serverStarted = time.Now()
+
+// Summarize current activity as plain JSON, read from the same collectors
+// /metrics exposes
+func statsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requests := make(map[string]int64)
+		var total int64
+		for station, n := range collectorValues(stationRequests) {
+			requests[station] = int64(n)
+			total += int64(n)
+		}
+		uptime := time.Since(serverStarted)
+		c.JSON(http.StatusOK, gin.H{
+			"active_streams":         int64(metricValue(activeStreams)),
+			"stream_errors":          int64(metricValue(streamErrors)),
+			"station_requests":       requests,
+			"station_requests_total": total,
+			"started":                serverStarted.UTC().Format(time.RFC3339),
+			"uptime":                 uptime.Truncate(time.Second).String(),
+			"uptime_seconds":         int64(uptime.Seconds()),
+		})
+	}
+}
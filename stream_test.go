@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamForwardsTrailers(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 4096)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Stream-Checksum")
+		w.Header().Set("Content-Type", "audio/mpeg")
+		// Flushing between writes forces a chunked response
+		for i := 0; i < len(audio); i += 1024 {
+			w.Write(audio[i : i+1024])
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("X-Stream-Checksum", "abc123")
+	}))
+	defer upstream.Close()
+
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Chunked", URL: upstream.URL + "/live"})
+	srv := streamTestServer(t, testConfig(catalog.URL))
+
+	resp, err := http.Get(srv.URL + "/stream/Chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading stream: %v", err)
+	}
+	if !bytes.Equal(body, audio) {
+		t.Fatalf("got %d bytes, want the upstream's %d", len(body), len(audio))
+	}
+	if got := resp.Trailer.Get("X-Stream-Checksum"); got != "abc123" {
+		t.Errorf("trailer X-Stream-Checksum = %q, want abc123", got)
+	}
+}
+
+func TestStreamRejectsHTMLPages(t *testing.T) {
+	page := "<!DOCTYPE html><html><body>Service unavailable</body></html>"
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"declared html", "text/html; charset=utf-8"},
+		{"sniffed html", "audio/mpeg"},
+	}
+	for _, tt := range tests {
+		for _, relay := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s relay=%t", tt.name, relay), func(t *testing.T) {
+				upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", tt.contentType)
+					io.WriteString(w, page)
+				}))
+				defer upstream.Close()
+
+				catalog := catalogServer(t, RadioStation{ID: 1, Name: "Down", URL: upstream.URL + "/live"})
+				config := testConfig(catalog.URL)
+				config.Relay = relay
+				srv := streamTestServer(t, config)
+
+				before := metricValue(htmlResponses)
+				resp, err := http.Get(srv.URL + "/stream/Down")
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusBadGateway {
+					t.Fatalf("status = %d, want 502", resp.StatusCode)
+				}
+				if bytes.Contains(body, []byte("Service unavailable")) {
+					t.Error("the HTML page was passed on to the client")
+				}
+				if got := metricValue(htmlResponses) - before; got != 1 {
+					t.Errorf("radio_upstream_html_responses_total grew by %v, want 1", got)
+				}
+			})
+		}
+	}
+}
+
+func TestStreamStartTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Headers arrive promptly but the audio never does
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Slow", URL: upstream.URL + "/live"})
+	config := testConfig(catalog.URL)
+	config.StreamStartTimeout = 200 * time.Millisecond
+	config.StreamRetries = 0
+	srv := streamTestServer(t, config)
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "/stream/Slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %s, want about the start timeout", elapsed)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	first := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 256)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Some audio, then silence mid-stream
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(first)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Stall", URL: upstream.URL + "/live"})
+	config := testConfig(catalog.URL)
+	config.StreamIdleTimeout = 200 * time.Millisecond
+	config.FlushInterval = 10 * time.Millisecond
+	srv := streamTestServer(t, config)
+
+	resp, err := http.Get(srv.URL + "/stream/Stall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// The start timeout doesn't apply once audio is flowing
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	done := make(chan []byte)
+	go func() {
+		body, _ := io.ReadAll(resp.Body)
+		done <- body
+	}()
+	select {
+	case body := <-done:
+		if !bytes.Equal(body, first) {
+			t.Errorf("got %d bytes before the stall, want %d", len(body), len(first))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled stream was not closed")
+	}
+}
+
+func TestStreamPassesRangeRequests(t *testing.T) {
+	file := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x64}, 1024)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "episode.mp3", time.Time{}, bytes.NewReader(file))
+	}))
+	defer upstream.Close()
+
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Episode", URL: upstream.URL + "/episode.mp3"})
+	srv := streamTestServer(t, testConfig(catalog.URL))
+
+	t.Run("ranged", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream/Episode", nil)
+		req.Header.Set("Range", "bytes=100-199")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("status = %d, want 206", resp.StatusCode)
+		}
+		if got, want := resp.Header.Get("Content-Range"), "bytes 100-199/4096"; got != want {
+			t.Errorf("Content-Range = %q, want %q", got, want)
+		}
+		if resp.ContentLength != 100 || !bytes.Equal(body, file[100:200]) {
+			t.Errorf("got %d bytes (Content-Length %d), want bytes 100-199", len(body), resp.ContentLength)
+		}
+	})
+
+	t.Run("whole file", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/stream/Episode")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want 200", resp.StatusCode)
+		}
+		if resp.Header.Get("Accept-Ranges") != "bytes" {
+			t.Errorf("Accept-Ranges = %q, want bytes", resp.Header.Get("Accept-Ranges"))
+		}
+		if resp.ContentLength != int64(len(file)) || !bytes.Equal(body, file) {
+			t.Errorf("got %d bytes (Content-Length %d), want %d", len(body), resp.ContentLength, len(file))
+		}
+	})
+}
+
+func TestStreamExtensions(t *testing.T) {
+	upstream := endlessUpstream(t)
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Jazz", URL: upstream.URL + "/live"})
+	srv := streamTestServer(t, testConfig(catalog.URL))
+
+	tests := []struct {
+		param    string
+		wantName string
+		wantType string
+	}{
+		{"Jazz.mp3", "Jazz", "audio/mpeg"},
+		{"Jazz.aac", "Jazz", "audio/aac"},
+		{"Jazz.ogg", "Jazz", "audio/ogg"},
+		{"Jazz.OGG", "Jazz", "audio/ogg"},
+		{"Jazz", "Jazz", ""},
+		{"Jazz.fm", "Jazz.fm", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			name, contentType := splitStreamExtension(tt.param)
+			if name != tt.wantName || contentType != tt.wantType {
+				t.Errorf("splitStreamExtension(%q) = %q, %q, want %q, %q", tt.param, name, contentType, tt.wantName, tt.wantType)
+			}
+			if tt.wantName != "Jazz" {
+				return
+			}
+
+			resp, err := http.Get(srv.URL + "/stream/" + tt.param)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			want := tt.wantType
+			if want == "" {
+				want = "audio/mpeg"
+			}
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != want {
+				t.Errorf("GET /stream/%s = %d %q, want 200 %q", tt.param, resp.StatusCode, resp.Header.Get("Content-Type"), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Most names offered in a 404
+const maxSuggestions = 3
+
+// 404 for an unknown station, naming the closest catalog matches when
+// suggestions are enabled
+func (a *stationsAPI) respondStationNotFound(c *gin.Context, stations []RadioStation, name string) {
+	if !a.suggest {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+		return
+	}
+
+	suggestions := a.suggestStations(stations, name)
+	if len(suggestions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{
+		"error":       "Station not found; did you mean: " + strings.Join(suggestions, ", ") + "?",
+		"suggestions": suggestions,
+	})
+}
+
+// Catalog names within a small edit distance of name, closest first
+func (a *stationsAPI) suggestStations(stations []RadioStation, name string) []string {
+	key := a.names.key(name)
+	limit := len(key) / 3
+	if limit < 2 {
+		limit = 2
+	}
+
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	for _, station := range stations {
+		d := editDistance(key, station.MatchKey)
+		if d <= limit || strings.HasPrefix(station.MatchKey, key) {
+			matches = append(matches, match{station.Name, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	var names []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		names = append(names, matches[i].name)
+	}
+	return names
+}
+
+// Levenshtein distance over runes
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
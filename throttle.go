@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Suppresses repeats of a per-key log message within an interval
+type logThrottle struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newLogThrottle(interval time.Duration) *logThrottle {
+	return &logThrottle{
+		interval: interval,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// Report whether a message for key may be logged now
+func (t *logThrottle) allow(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	t.last[key] = now
+	return true
+}
+
+var slowWarnings = newLogThrottle(time.Minute)
+
+// Warn when an upstream was slower than threshold (0 disables), at most
+// once a minute per key
+func warnSlow(logger *log.Logger, threshold time.Duration, what, key string, elapsed time.Duration) {
+	if threshold <= 0 || elapsed < threshold || !slowWarnings.allow(what+":"+key) {
+		return
+	}
+	logger.Printf("Warning: slow %s for %s: %v (threshold %v)", what, key, elapsed.Round(time.Millisecond), threshold)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Per-station availability record, kept for the life of the process
+type stationUptime struct {
+	// Series label, see RadioStation.stateLabel
+	label     string
+	firstSeen time.Time
+
+	streams      int
+	flowingSince time.Time
+	flowing      time.Duration
+
+	// Left the catalog while streams were still running; dropped when
+	// the last one ends
+	removed bool
+
+	down       bool
+	downSince  time.Time
+	outages    int
+	outageTime time.Duration
+}
+
+func (s *stationUptime) totals(now time.Time) (flowing, outage time.Duration) {
+	flowing, outage = s.flowing, s.outageTime
+	if s.streams > 0 {
+		flowing += now.Sub(s.flowingSince)
+	}
+	if s.down {
+		outage += now.Sub(s.downSince)
+	}
+	return flowing, outage
+}
+
+// Share of observed time the station was not in an outage
+func (s *stationUptime) ratio(now time.Time) float64 {
+	observed := now.Sub(s.firstSeen)
+	if observed <= 0 {
+		return 1
+	}
+	_, outage := s.totals(now)
+	return 1 - outage.Seconds()/observed.Seconds()
+}
+
+type uptimeReport struct {
+	Station         string  `json:"station"`
+	Up              bool    `json:"up"`
+	UptimeRatio     float64 `json:"uptime_ratio"`
+	ActiveStreams   int     `json:"active_streams"`
+	FlowingSeconds  float64 `json:"flowing_seconds"`
+	Outages         int     `json:"outages"`
+	OutageSeconds   float64 `json:"outage_seconds"`
+	ObservedSeconds float64 `json:"observed_seconds"`
+}
+
+// Turns stream outcomes and probe results into per-station uptime, keyed
+// by RadioStation.stateKey. It is a Prometheus collector so
+// radio_station_uptime_ratio is computed at scrape time.
+type uptimeTracker struct {
+	mu       sync.Mutex
+	stations map[string]*stationUptime
+}
+
+var uptimeRatioDesc = prometheus.NewDesc(
+	"radio_station_uptime_ratio",
+	"Share of time since first observed that the station was not in an outage",
+	[]string{"station"}, nil,
+)
+
+var stationUpDesc = prometheus.NewDesc(
+	"radio_station_up",
+	"1 if the station's most recent probe or stream succeeded, 0 if it failed",
+	[]string{"station"}, nil,
+)
+
+var stationUptimes = newUptimeTracker()
+
+func newUptimeTracker() *uptimeTracker {
+	t := &uptimeTracker{stations: make(map[string]*stationUptime)}
+	prometheus.MustRegister(t)
+	return t
+}
+
+// Caller must hold the lock
+func (t *uptimeTracker) get(station RadioStation, now time.Time) *stationUptime {
+	s, ok := t.stations[station.stateKey()]
+	if !ok {
+		s = &stationUptime{label: station.stateLabel(), firstSeen: now}
+		t.stations[station.stateKey()] = s
+	}
+	return s
+}
+
+func (t *uptimeTracker) markUp(station RadioStation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	s := t.get(station, now)
+	if s.down {
+		s.outageTime += now.Sub(s.downSince)
+		s.down = false
+	}
+}
+
+func (t *uptimeTracker) markDown(station RadioStation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	s := t.get(station, now)
+	if !s.down {
+		s.down = true
+		s.downSince = now
+		s.outages++
+	}
+}
+
+func (t *uptimeTracker) streamStarted(station RadioStation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	s := t.get(station, now)
+	if s.streams == 0 {
+		s.flowingSince = now
+	}
+	s.streams++
+	s.removed = false
+}
+
+func (t *uptimeTracker) streamEnded(station RadioStation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stations[station.stateKey()]
+	if !ok || s.streams == 0 {
+		return
+	}
+	s.streams--
+	if s.streams == 0 {
+		s.flowing += time.Since(s.flowingSince)
+		if s.removed {
+			delete(t.stations, station.stateKey())
+		}
+	}
+}
+
+// Drop a station that left the catalog so its series disappear, once its
+// last stream ends
+func (t *uptimeTracker) forget(station RadioStation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stations[station.stateKey()]
+	if !ok {
+		return
+	}
+	if s.streams > 0 {
+		s.removed = true
+		return
+	}
+	delete(t.stations, station.stateKey())
+}
+
+func (t *uptimeTracker) snapshot() []uptimeReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	reports := make([]uptimeReport, 0, len(t.stations))
+	for _, s := range t.stations {
+		flowing, outage := s.totals(now)
+		reports = append(reports, uptimeReport{
+			Station:         s.label,
+			Up:              !s.down,
+			UptimeRatio:     s.ratio(now),
+			ActiveStreams:   s.streams,
+			FlowingSeconds:  flowing.Seconds(),
+			Outages:         s.outages,
+			OutageSeconds:   outage.Seconds(),
+			ObservedSeconds: now.Sub(s.firstSeen).Seconds(),
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Station < reports[j].Station })
+	return reports
+}
+
+func (t *uptimeTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- uptimeRatioDesc
+	ch <- stationUpDesc
+}
+
+func (t *uptimeTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for _, s := range t.stations {
+		ch <- prometheus.MustNewConstMetric(uptimeRatioDesc, prometheus.GaugeValue, s.ratio(now), s.label)
+		up := 1.0
+		if s.down {
+			up = 0
+		}
+		ch <- prometheus.MustNewConstMetric(stationUpDesc, prometheus.GaugeValue, up, s.label)
+	}
+}
+
+func uptimeHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, stationUptimes.snapshot())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func uptimeReportFor(name string) (uptimeReport, bool) {
+	for _, r := range stationUptimes.snapshot() {
+		if r.Station == name {
+			return r, true
+		}
+	}
+	return uptimeReport{}, false
+}
+
+func TestUptimeForgetWaitsForStreams(t *testing.T) {
+	station := RadioStation{ID: 1, Name: "Forgotten FM", MatchKey: "forgotten fm"}
+	name := station.Name
+	stationUptimes.streamStarted(station)
+	stationUptimes.forget(station)
+
+	report, ok := uptimeReportFor(name)
+	if !ok || report.ActiveStreams != 1 {
+		t.Fatalf("report %+v (present %v), want the station kept with 1 active stream", report, ok)
+	}
+
+	stationUptimes.streamEnded(station)
+	if report, ok := uptimeReportFor(name); ok {
+		t.Fatalf("removed station still reported after its last stream: %+v", report)
+	}
+
+	// A late end must not bring the series back
+	stationUptimes.streamEnded(station)
+	if report, ok := uptimeReportFor(name); ok {
+		t.Fatalf("stream end resurrected a removed station: %+v", report)
+	}
+}
+
+func TestDuplicateStationsKeepSeparateState(t *testing.T) {
+	a := RadioStation{ID: 7, Name: "Twin FM", MatchKey: "twin fm", Duplicate: true}
+	b := RadioStation{ID: 8, Name: "Twin FM", MatchKey: "twin fm", Duplicate: true}
+
+	stationUptimes.markDown(a)
+	stationUptimes.markUp(b)
+	if report, ok := uptimeReportFor("Twin FM#7"); !ok || report.Up {
+		t.Errorf("first twin: %+v (present %v), want down", report, ok)
+	}
+	if report, ok := uptimeReportFor("Twin FM#8"); !ok || !report.Up {
+		t.Errorf("second twin: %+v (present %v), want up", report, ok)
+	}
+
+	cooldowns := newCooldownTracker(Config{CooldownFailures: 1, CooldownWindow: time.Minute, CooldownDuration: time.Minute})
+	cooldowns.failure(a)
+	if cooldowns.check(a) == nil {
+		t.Error("failing twin not cooling down")
+	}
+	if e := cooldowns.check(b); e != nil {
+		t.Errorf("other twin cooling down too: %+v", e)
+	}
+	cooldowns.success(a)
+}
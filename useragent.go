@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+// Rotates through the configured upstream User-Agents. Round-robin keeps a
+// separate position per origin host, so concurrent connections to the same
+// origin present distinct agents.
+type userAgentPool struct {
+	agents []string
+	random bool
+
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func newUserAgentPool(config Config) *userAgentPool {
+	return &userAgentPool{
+		agents: config.UpstreamUserAgents,
+		random: config.UserAgentRotation == "random",
+		next:   make(map[string]int),
+	}
+}
+
+func (p *userAgentPool) pick(host string) string {
+	if len(p.agents) == 1 {
+		return p.agents[0]
+	}
+	if p.random {
+		return p.agents[rand.Intn(len(p.agents))]
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.next[host]
+	p.next[host] = (i + 1) % len(p.agents)
+	return p.agents[i]
+}
+
+// The first configured User-Agent, for one-off requests such as catalog
+// fetches, probes and metadata reads
+func primaryUserAgent(config Config) string {
+	return config.UpstreamUserAgents[0]
+}
+
+// Headers that describe the connection or framing rather than the client
+var unforwardableHeaders = map[string]bool{
+	"Host":              true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Te":                true,
+	"Range":             true,
+	"Icy-Metadata":      true,
+}
+
+// Copy the -forward-headers allowlist from the client's request to an
+// upstream request, overriding what the proxy set itself
+func forwardClientHeaders(config Config, from, to *http.Request) {
+	for _, name := range config.ForwardHeaders {
+		if values := from.Header.Values(name); len(values) > 0 {
+			to.Header[name] = append([]string(nil), values...)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUpstreamRequestHeaders(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []http.Header
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Clone())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte{0xFF, 0xFB, 0x90, 0x64})
+	}))
+	defer upstream.Close()
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Jazz", URL: upstream.URL + "/live"})
+
+	config := testConfig(catalog.URL)
+	config.UpstreamUserAgents = []string{"RadioA/1.0", "RadioB/2.0"}
+	config.ForwardHeaders = []string{"Accept-Language", "X-Listener-Id"}
+	srv := streamTestServer(t, config)
+
+	for range 2 {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/stream/Jazz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "Browser/1.0")
+		req.Header.Set("Accept-Language", "sw, en;q=0.8")
+		req.Header.Set("X-Listener-Id", "42")
+		req.Header.Set("X-Secret", "hunter2")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 2 {
+		t.Fatalf("upstream saw %d requests, want 2", len(seen))
+	}
+	for i, want := range []string{"RadioA/1.0", "RadioB/2.0"} {
+		h := seen[i]
+		if got := h.Get("User-Agent"); got != want {
+			t.Errorf("request %d: User-Agent = %q, want %q", i+1, got, want)
+		}
+		if got := h.Get("Accept-Language"); got != "sw, en;q=0.8" {
+			t.Errorf("request %d: Accept-Language = %q, want it forwarded", i+1, got)
+		}
+		if got := h.Get("X-Listener-Id"); got != "42" {
+			t.Errorf("request %d: X-Listener-Id = %q, want it forwarded", i+1, got)
+		}
+		if got := h.Get("X-Secret"); got != "" {
+			t.Errorf("request %d: X-Secret = %q, want it dropped", i+1, got)
+		}
+		if got := h.Get("Icy-MetaData"); got != "1" {
+			t.Errorf("request %d: Icy-MetaData = %q, want 1", i+1, got)
+		}
+	}
+}
+
+func TestForwardClientHeadersOverridesOwn(t *testing.T) {
+	config := testConfig("http://catalog.example/")
+	config.ForwardHeaders = []string{"Accept-Language"}
+	from := httptest.NewRequest(http.MethodGet, "/stream/Jazz", nil)
+	from.Header.Add("Accept-Language", "sw")
+	from.Header.Add("Accept-Language", "en")
+	to := httptest.NewRequest(http.MethodGet, "http://jazz.example/live", nil)
+	to.Header.Set("Accept-Language", "fr")
+
+	forwardClientHeaders(config, from, to)
+	if got := strings.Join(to.Header.Values("Accept-Language"), ","); got != "sw,en" {
+		t.Errorf("Accept-Language = %q, want the client's sw,en", got)
+	}
+}
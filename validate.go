@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Check that the catalog can be fetched and decoded, optionally probe a
+// few stations, print a summary and return the process exit code. The
+// catalog fetch is bounded by the API timeout and each probe by the probe
+// timeout, so this cannot hang a CI job.
+func runValidate(config Config, logger *log.Logger) int {
+	fmt.Println("Configuration OK")
+
+	api := newStationsAPI(config, logger)
+	ctx, cancel := context.WithTimeout(context.Background(), config.APITimeout)
+	stations, err := api.fetch(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Catalog FAILED: %v\n", err)
+		return 1
+	}
+	if len(stations) == 0 {
+		fmt.Fprintln(os.Stderr, "Catalog FAILED: no stations")
+		return 1
+	}
+	fmt.Printf("Catalog OK: %d stations from %d source(s)\n", len(stations), len(api.sources))
+
+	if config.ValidateProbe <= 0 {
+		return 0
+	}
+	prober := newAvailabilityProber(config, logger, api, nil, nil)
+	sample := stations[:min(config.ValidateProbe, len(stations))]
+	reachable := 0
+	for _, station := range sample {
+		ctx, cancel := context.WithTimeout(context.Background(), config.ProbeTimeout)
+		ok := prober.probe(ctx, station.URL)
+		cancel()
+		status := "unreachable"
+		if ok {
+			status = "ok"
+			reachable++
+		}
+		fmt.Printf("  %s: %s\n", station.Name, status)
+	}
+	fmt.Printf("Probed %d stations, %d reachable\n", len(sample), reachable)
+	// Single stations go down all the time; only fail when none answer
+	if reachable == 0 {
+		return 1
+	}
+	return 0
+}
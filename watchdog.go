@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fires onExpire if it isn't reset within the current timeout. Used to
+// cancel upstream requests that never start or go quiet mid-stream.
+type streamWatchdog struct {
+	onExpire func()
+	fired    atomic.Bool
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func newStreamWatchdog(timeout time.Duration, onExpire func()) *streamWatchdog {
+	w := &streamWatchdog{onExpire: onExpire}
+	w.reset(timeout)
+	return w
+}
+
+func (w *streamWatchdog) expire() {
+	w.fired.Store(true)
+	w.onExpire()
+}
+
+// Restart the countdown; a non-positive timeout disarms the watchdog
+func (w *streamWatchdog) reset(timeout time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if timeout <= 0 {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		return
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(timeout, w.expire)
+		return
+	}
+	w.timer.Reset(timeout)
+}
+
+func (w *streamWatchdog) stop() {
+	w.reset(0)
+}
+
+func (w *streamWatchdog) expired() bool {
+	return w.fired.Load()
+}
+
+// Resets the watchdog to the idle timeout whenever bytes arrive
+type idleReader struct {
+	r    io.Reader
+	wd   *streamWatchdog
+	idle time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.wd.reset(r.idle)
+	}
+	return n, err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+type wsStreamInfo struct {
+	Station     string `json:"station"`
+	ContentType string `json:"content_type"`
+	IcyName     string `json:"icy_name,omitempty"`
+	IcyBr       string `json:"icy_br,omitempty"`
+}
+
+// Stream a station over a WebSocket for clients behind proxies that buffer
+// chunked audio. The first message is a JSON text frame describing the
+// stream; audio follows as binary frames. Uses the relay when enabled.
+// Cooldowns and duplicate-stream handling are shared with /stream.
+func wsStreamHandler(config Config, live *liveConfig, logger *log.Logger, api *stationsAPI, overrides *overrideStore, origins *originLimiter, userAgents *userAgentPool, cooldowns *cooldownTracker, sessions *streamSessions, relay *relayHub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stationName := c.Param("station")
+		stationRequests.WithLabelValues(stationName).Inc()
+
+		stations, err := api.fetch(c.Request.Context())
+		if err != nil {
+			respondCatalogError(c, logger, err)
+			return
+		}
+		station, found := api.findStation(stations, stationName)
+		if !found {
+			api.respondStationNotFound(c, stations, stationName)
+			return
+		}
+		if e := cooldowns.check(station); e != nil {
+			respondLimit(c, e)
+			return
+		}
+		session, ok := sessions.start(c, station)
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "Already streaming this station"})
+			return
+		}
+		defer sessions.end(session)
+
+		if o, ok := overrides.lookup(station); ok {
+			station.URL = o.URL
+		}
+		streamURL, err := resolveStationURL(c.Request.Context(), config, station.URL)
+		if isStationURLError(err) {
+			respondStationURLError(c, logger, station, err)
+			return
+		}
+		if err != nil {
+			streamErrors.Inc()
+			stationUptimes.markDown(station)
+			cooldowns.failure(station)
+			logger.Printf("Playlist resolution for %s failed: %v", station.Name, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resolve station playlist"})
+			return
+		}
+		station.URL = streamURL
+
+		// Connect before upgrading so failures still get a JSON error
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		session.bind(cancel)
+		info := wsStreamInfo{Station: station.Name}
+		var next func() ([]byte, error)
+		var joined *relayFeed
+		var watchdog *streamWatchdog
+		if relay != nil {
+			feed, ch, backlog, err := relay.join(c.Request.Context(), station)
+			if err != nil {
+				respondWSUpstreamError(c, logger, cooldowns, station, err)
+				return
+			}
+			defer relay.leave(feed, ch)
+			joined = feed
+			info.ContentType, info.IcyName, info.IcyBr = feed.contentType, feed.icy["icy-name"], feed.icy["icy-br"]
+			next = func() ([]byte, error) {
+				if backlog != nil {
+					chunk := backlog
+					backlog = nil
+					return chunk, nil
+				}
+				select {
+				case chunk, ok := <-ch:
+					if !ok {
+						return nil, io.EOF
+					}
+					return chunk, nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		} else {
+			resp, release, err := dialUpstream(ctx, config, origins, userAgents, station.URL, false, c.Request)
+			if err != nil {
+				respondWSUpstreamError(c, logger, cooldowns, station, err)
+				return
+			}
+			defer release()
+			defer resp.Body.Close()
+			watchdog = newStreamWatchdog(config.StreamIdleTimeout, cancel)
+			defer watchdog.stop()
+			upstream := bufio.NewReaderSize(&idleReader{r: resp.Body, wd: watchdog, idle: config.StreamIdleTimeout}, config.UpstreamReadBuffer)
+			if config.RejectHTML && isHTMLResponse(resp, upstream) {
+				respondWSUpstreamError(c, logger, cooldowns, station, errHTMLResponse)
+				return
+			}
+			info.ContentType, info.IcyName, info.IcyBr = getContentType(resp, peekHead(upstream)), resp.Header.Get("icy-name"), resp.Header.Get("icy-br")
+			buf := make([]byte, config.UpstreamReadBuffer)
+			next = func() ([]byte, error) {
+				n, err := upstream.Read(buf)
+				if n > 0 {
+					return buf[:n], nil
+				}
+				return nil, err
+			}
+		}
+
+		server := websocket.Server{
+			Handshake: func(_ *websocket.Config, r *http.Request) error {
+				if origin := r.Header.Get("Origin"); origin != "" && !publicCORSPolicy(*live.get()).allowsOrigin(origin) {
+					return errors.New("origin not allowed")
+				}
+				return nil
+			},
+			Handler: func(ws *websocket.Conn) {
+				defer ws.Close()
+				stationUptimes.markUp(station)
+				cooldowns.success(station)
+				activeStreams.Inc()
+				defer activeStreams.Dec()
+				stationUptimes.streamStarted(station)
+				defer stationUptimes.streamEnded(station)
+
+				started := time.Now()
+				var sent int64
+				defer func() { recordStreamEnd(c, station.Name, station.URL, sent, started) }()
+
+				// The client never sends audio; a failed read means it left
+				go func() {
+					var discard []byte
+					for websocket.Message.Receive(ws, &discard) == nil {
+					}
+					cancel()
+				}()
+
+				meta, _ := json.Marshal(info)
+				if websocket.Message.Send(ws, string(meta)) != nil {
+					return
+				}
+				for {
+					chunk, err := next()
+					if len(chunk) > 0 {
+						if websocket.Message.Send(ws, chunk) != nil {
+							streamClientDisconnects.WithLabelValues(station.Name).Inc()
+							return
+						}
+						if sent == 0 {
+							recordStreamTTFB(c, station.Name)
+						}
+						sent += int64(len(chunk))
+					}
+					if err != nil {
+						switch {
+						case watchdog != nil && watchdog.expired():
+							streamUpstreamErrors.WithLabelValues(station.Name).Inc()
+						case ctx.Err() != nil:
+							streamClientDisconnects.WithLabelValues(station.Name).Inc()
+						case joined != nil:
+							relay.recordFeedEnd(joined, station.Name)
+						case err == io.EOF:
+							streamCompletions.WithLabelValues(station.Name).Inc()
+						default:
+							streamUpstreamErrors.WithLabelValues(station.Name).Inc()
+						}
+						if ctx.Err() == nil {
+							logger.Printf("WebSocket stream for %s ended: %v", station.Name, err)
+						}
+						return
+					}
+				}
+			},
+		}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func respondWSUpstreamError(c *gin.Context, logger *log.Logger, cooldowns *cooldownTracker, station RadioStation, err error) {
+	var limitErr *limitError
+	if errors.As(err, &limitErr) {
+		respondLimit(c, limitErr)
+		return
+	}
+	if isStationURLError(err) {
+		respondStationURLError(c, logger, station, err)
+		return
+	}
+	streamErrors.Inc()
+	stationUptimes.markDown(station)
+	cooldowns.failure(station)
+	if errors.Is(err, errHTMLResponse) {
+		htmlResponses.Inc()
+		logger.Printf("Upstream for %s returned an HTML page instead of audio", station.Name)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream returned an HTML page instead of audio"})
+		return
+	}
+	logger.Printf("WebSocket stream for %s unavailable: %v", station.Name, err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to stream"})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketSharesStreamSessions(t *testing.T) {
+	upstream := endlessUpstream(t)
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Jazz", URL: upstream.URL + "/live"})
+
+	t.Run("reject", func(t *testing.T) {
+		config := testConfig(catalog.URL)
+		config.DuplicateStreams = "reject"
+		srv := streamTestServer(t, config)
+
+		first := openStream(t, srv.URL+"/stream/Jazz", "")
+		defer first.Body.Close()
+		resp, err := http.Get(srv.URL + "/ws/Jazz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("WebSocket next to a stream: status = %d, want 409", resp.StatusCode)
+		}
+	})
+
+	t.Run("takeover", func(t *testing.T) {
+		config := testConfig(catalog.URL)
+		config.DuplicateStreams = "takeover"
+		srv := streamTestServer(t, config)
+
+		wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/Jazz"
+		ws, err := websocket.Dial(wsURL, "", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		var info string
+		if err := websocket.Message.Receive(ws, &info); err != nil {
+			t.Fatal(err)
+		}
+
+		second := openStream(t, srv.URL+"/stream/Jazz", "")
+		defer second.Body.Close()
+		if second.StatusCode != http.StatusOK {
+			t.Fatalf("stream next to a WebSocket: status = %d, want 200", second.StatusCode)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var chunk []byte
+			if err := websocket.Message.Receive(ws, &chunk); err != nil {
+				if strings.Contains(err.Error(), "timeout") {
+					t.Fatal("the WebSocket stream was not closed")
+				}
+				return
+			}
+		}
+	})
+}
+
+func TestWebSocketCooldown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+	catalog := catalogServer(t, RadioStation{ID: 1, Name: "Down", URL: upstream.URL + "/live"})
+	config := testConfig(catalog.URL)
+	config.CooldownFailures = 1
+	config.CooldownWindow = time.Minute
+	config.CooldownDuration = time.Minute
+	srv := streamTestServer(t, config)
+
+	for i, want := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		resp, err := http.Get(srv.URL + "/ws/Down")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("attempt %d: status = %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+}